@@ -0,0 +1,100 @@
+package bipf
+
+import (
+	"io"
+)
+
+const encoderBufferSize = 4096
+
+// Encoder writes BIPF values to an output stream.
+type Encoder struct {
+	stream *stream
+}
+
+// NewEncoder returns a new encoder that writes to w. Encoded values are
+// buffered in memory and written to w once enough of them accumulate, call
+// Flush to write out the values which are still buffered.
+func NewEncoder(w io.Writer) *Encoder {
+	return ConfigDefault.NewEncoder(w)
+}
+
+// Encode writes the BIPF encoding of v to the stream. Consecutive values are
+// concatenated without any separators.
+//
+// See the documentation for Marshal for details about the conversion of Go
+// values to BIPF. If encoding fails nothing is written to the stream.
+func (enc *Encoder) Encode(v any) error {
+	start := enc.stream.Buffered()
+	if err := enc.stream.WriteVal(v); err != nil {
+		enc.stream.truncate(start)
+		return err
+	}
+	if enc.stream.Buffered() >= encoderBufferSize {
+		return enc.stream.Flush()
+	}
+	return nil
+}
+
+// Flush writes all buffered values to the underlying io.Writer.
+func (enc *Encoder) Flush() error {
+	return enc.stream.Flush()
+}
+
+// Decoder reads consecutive BIPF values from an input stream.
+type Decoder struct {
+	iter *iterator
+}
+
+// NewDecoder returns a new decoder that reads from r. The decoder reads from r
+// in small chunks and never buffers more than one chunk of data at a time.
+func NewDecoder(r io.Reader) *Decoder {
+	return ConfigDefault.NewDecoder(r)
+}
+
+// Decode reads the next BIPF value from its input and stores it in the value
+// pointed to by v. If there are no more values in the input Decode returns
+// io.EOF. If the input ends in the middle of a value Decode returns
+// io.ErrUnexpectedEOF.
+//
+// See the documentation for Unmarshal for details about the conversion of BIPF
+// into a Go value.
+func (dec *Decoder) Decode(v any) error {
+	more, err := dec.iter.more()
+	if err != nil {
+		return err
+	}
+	if !more {
+		return io.EOF
+	}
+	return noEOF(dec.iter.ReadVal(v))
+}
+
+// DisallowUnknownFields causes the decoder to return an error when an OBJECT
+// decoded into a struct contains a key which doesn't match any of the struct's
+// fields. See Config.DisallowUnknownFields.
+func (dec *Decoder) DisallowUnknownFields() {
+	cfg := dec.iter.cfg.configBeforeFrozen
+	cfg.DisallowUnknownFields = true
+	dec.iter.cfg = dec.iter.cfg.derive(cfg)
+}
+
+// UseNumber causes the decoder to store INTs and DOUBLEs decoded into an
+// interface value as a Number. See Config.UseNumber.
+func (dec *Decoder) UseNumber() {
+	cfg := dec.iter.cfg.configBeforeFrozen
+	cfg.UseNumber = true
+	dec.iter.cfg = dec.iter.cfg.derive(cfg)
+}
+
+// More reports whether there is another value in the input.
+func (dec *Decoder) More() bool {
+	more, err := dec.iter.more()
+	return err == nil && more
+}
+
+// InputOffset returns the input stream byte offset of the current decoder
+// position. The offset gives the location of the end of the most recently
+// decoded value and the beginning of the next one.
+func (dec *Decoder) InputOffset() int64 {
+	return int64(dec.iter.numOfReadBytes)
+}
@@ -1,12 +1,5 @@
 package bipf
 
-import (
-	"errors"
-	"io"
-)
-
-const tagKey = "bipf"
-
 // Marshal returns the BIPF encoding of v.
 //
 // Marshal traverses the value v recursively. If an encountered value implements
@@ -15,7 +8,8 @@ const tagKey = "bipf"
 // the value implements encoding.BinaryMarshaler instead, Marshal calls its
 // MarshalBinary method and encodes the result as a BIPF BUFFER. The nil pointer
 // exception is not strictly necessary but mimics a similar, necessary exception
-// in the behavior of UnmarshalBIPF.
+// in the behavior of UnmarshalBIPF. An error is returned if MarshalBIPF doesn't
+// produce exactly one well-formed BIPF value.
 //
 // Otherwise, Marshal uses the following type-dependent default encodings:
 //
@@ -23,15 +17,21 @@ const tagKey = "bipf"
 //
 // Floating point numbers encode as BIPF DOUBLE.
 //
-// Integer numbers encode as BIPF INT. BIPF only supports 32-bit integers. The
-// value of the encoded integer must fit in 32 bytes or an error will be
-// returned.
+// Integer numbers encode as BIPF INT. BIPF only supports 32-bit integers. By
+// default the value of the encoded integer must fit in an int32 or an error
+// will be returned, see Config.IntegerPolicy for alternatives.
 //
 // String values directly encode as BIPF STRING.
 //
 // Array and slice values encode as BIPF ARRAY, except that []byte encodes as a
 // BIPF BUFFER, and a nil slice encodes as the BIPF BOOLNULL.
 //
+// RawMessage values are written verbatim, a nil RawMessage encodes as the BIPF
+// BOOLNULL value.
+//
+// Values of types registered using API.RegisterExtendedType and Extended
+// values encode as BIPF EXTENDED.
+//
 // Struct values encode as BIPF OBJECT. Each exported struct field becomes a
 // member of the object, using the field name as the object key, unless the
 // field is omitted for one of the reasons given below.
@@ -89,7 +89,8 @@ const tagKey = "bipf"
 //
 // 3) Otherwise there are multiple fields, and all are ignored; no error occurs.
 //
-// Map values encode as BIPF objects.
+// Map values encode as BIPF objects. The order of the entries is random unless
+// Config.SortMapKeys is set.
 //
 // Pointer values encode as the value pointed to.
 // A nil pointer encodes as the BIPF BOOLNULL value.
@@ -98,16 +99,24 @@ const tagKey = "bipf"
 // A nil interface value encodes as the BIPF BOOLNULL value.
 //
 // Channel, complex, and function values cannot be encoded in BIPF.
+// Attempting to encode such a value causes Marshal to return
+// an UnsupportedTypeError. Integers which can't be encoded according to
+// Config.IntegerPolicy cause Marshal to return an UnsupportedValueError.
 func Marshal(v any) ([]byte, error) {
-	stream := streamPool.BorrowStream(nil)
-	defer streamPool.ReturnStream(stream)
-	if err := stream.WriteVal(v); err != nil {
-		return nil, err
-	}
-	result := stream.Buffer()
-	copied := make([]byte, len(result))
-	copy(copied, result)
-	return copied, nil
+	return ConfigDefault.Marshal(v)
+}
+
+// EncodingLength returns the length of the BIPF encoding of v, that is the
+// length of the output of Marshal, without producing the encoding. It can be
+// used to allocate buffers of the exact size upfront.
+//
+// The rules used by Marshal, such as omitempty and the encoding of nil values
+// as BIPF BOOLNULL, are applied in the same way and the same errors are
+// returned. Since the length of their output can't be known otherwise,
+// Marshaler, encoding.BinaryMarshaler and custom encoders are called and
+// their output is discarded.
+func EncodingLength(v any) (int, error) {
+	return ConfigDefault.EncodingLength(v)
 }
 
 // Unmarshal parses the BIPF-encoded data and stores the result
@@ -118,16 +127,18 @@ func Marshal(v any) ([]byte, error) {
 // Marshal uses, allocating maps, slices, and pointers as necessary.
 //
 // To unmarshal BIPF into a value implementing the Unmarshaler interface,
-// Unmarshal calls that value's UnmarshalBIPF method, including when the input
-// is a BIPF BOOLNULL. Otherwise, if the value implements
+// Unmarshal calls that value's UnmarshalBIPF method with the entire encoded
+// value, tag included, including when the input is a BIPF BOOLNULL (see
+// Config.LegacyMarshalers for an alternative). Otherwise, if the value implements
 // encoding.BinaryUnmarshaler and the input is a BIPF BUFFER, Unmarshal calls
 // that value's UnmarshalBinary method with the contents of the BUFFER.
 //
 // To unmarshal BIPF into a struct, Unmarshal matches incoming object
 // keys to the keys used by Marshal (either the struct field name or its tag),
-// preferring an exact match but also accepting a case-insensitive match. By
+// preferring an exact match but also accepting a case-insensitive match
+// (see Config.CaseSensitive and Config.DisallowDuplicateFields). By
 // default, object keys which don't have a corresponding struct field are
-// ignored.
+// ignored (see Config.DisallowUnknownFields for an alternative).
 //
 // To unmarshal BIPF into an interface value,
 // Unmarshal stores one of these in the interface value:
@@ -140,30 +151,64 @@ func Marshal(v any) ([]byte, error) {
 //	map[any]any, for BIPF OBJECT
 //	bool, for BIPF BOOLNULL not set to null
 //	nil for BIPF BOOLNULL set to null
+//	int64 or uint64, for BIPF EXTENDED holding integers, see IntegerPolicyExtended
+//	the registered type or Extended, for other BIPF EXTENDED
+//
+// The types stored for BIPF OBJECT, INT and DOUBLE can be changed using
+// Config.ObjectRepresentation, Config.IntRepresentation and Config.UseNumber.
+//
+// To unmarshal BIPF into a RawMessage, Unmarshal stores a copy of the encoded
+// value, tag included.
+//
+// To unmarshal BIPF into an int64, uint64, uint32 or a type with such an
+// underlying type, Unmarshal also accepts a BIPF DOUBLE holding an integer
+// and, if Config.IntegerPolicy is IntegerPolicyExtended, a BIPF EXTENDED value
+// of subtype Config.Int64ExtendedType or Config.Uint64ExtendedType, as long as
+// the value fits in the target type.
 //
 // To unmarshal a BIPF array into a slice, Unmarshal resets the slice length
 // to zero and then appends each element to the slice.
 //
-// If the BIPF-encoded data contain an error, Unmarshal returns an error.
+// If the BIPF-encoded data contain an error, Unmarshal returns a SyntaxError.
 //
 // If a BIPF value is not appropriate for a given target type, or if a BIPF
-// number overflows or underflows the target type, Unmarshal returns an error.
+// number overflows or underflows the target type, Unmarshal returns an
+// UnmarshalTypeError.
+//
+// If a key of an OBJECT unmarshaled into a struct doesn't match any field and
+// Config.DisallowUnknownFields is set, Unmarshal returns an UnknownFieldError.
+// If multiple keys match the same field and Config.DisallowDuplicateFields is
+// set, Unmarshal returns a DuplicateFieldError.
+//
+// Decoded strings and byte slices hold copies of the input unless
+// Config.ZeroCopy is set.
 //
 // When unmarshaling BIPF STRING, invalid UTF-8 is not treated as an error.
 func Unmarshal(data []byte, v any) error {
-	iter := iteratorPool.BorrowIterator(data)
-	defer iteratorPool.ReturnIterator(iter)
-	if err := iter.ReadVal(v); err != nil {
-		return err
-	}
-	_, err := iter.ReadByte()
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return nil
-		}
-		return err
-	}
-	return errors.New("there are bytes left after unmarshal")
+	return ConfigDefault.Unmarshal(data, v)
+}
+
+// UnmarshalAt parses the BIPF-encoded value which starts at the given offset
+// in data and stores the result in the value pointed to by v. Unlike in the
+// case of Unmarshal, the data surrounding the value is ignored. This makes it
+// possible to decode a single value embedded in a larger document, for example
+// a value located using SeekKey.
+//
+// See the documentation for Unmarshal for details about the conversion of BIPF
+// into a Go value.
+func UnmarshalAt(data []byte, offset int, v any) error {
+	return ConfigDefault.UnmarshalAt(data, offset, v)
+}
+
+// UnmarshalPath locates the value found at the given path in the BIPF-encoded
+// data and stores it in the value pointed to by v. The path is interpreted in
+// the same way as in the case of CompilePath. If there is no value at the
+// given path UnmarshalPath returns an error.
+//
+// See the documentation for Unmarshal for details about the conversion of BIPF
+// into a Go value.
+func UnmarshalPath(data []byte, v any, path ...any) error {
+	return ConfigDefault.UnmarshalPath(data, v, path...)
 }
 
 type valueType byte
@@ -178,3 +223,7 @@ const (
 	valueTypeBoolNull valueType = 0b110
 	valueTypeExtended valueType = 0b111
 )
+
+func (t valueType) String() string {
+	return Kind(t).String()
+}
@@ -1,14 +1,27 @@
 package bipf_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"runtime"
+
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unsafe"
 
 	"github.com/boreq/go-bipf"
 	"github.com/boreq/go-bipf/internal"
 	"github.com/google/go-cmp/cmp"
+	"github.com/modern-go/reflect2"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
 )
@@ -488,6 +501,27 @@ func TestUnmarshalNative(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, []byte{0xDE, 0xAD, 0xBE, 0xEF}, v)
 	})
+
+	t.Run("float32", func(t *testing.T) {
+		for _, f := range []float64{0, -1.5, 1.5, math.SmallestNonzeroFloat64, math.Inf(-1)} {
+			b, err := bipf.Marshal(f)
+			require.NoError(t, err)
+
+			var v float32
+			require.NoError(t, bipf.Unmarshal(b, &v))
+			require.Equal(t, float32(f), v)
+		}
+	})
+
+	t.Run("float32_overflow", func(t *testing.T) {
+		for _, f := range []float64{math.MaxFloat64, -math.MaxFloat64} {
+			b, err := bipf.Marshal(f)
+			require.NoError(t, err)
+
+			var v float32
+			require.Error(t, bipf.Unmarshal(b, &v))
+		}
+	})
 }
 
 func TestUnmarshalSlicesAndArrays(t *testing.T) {
@@ -525,6 +559,18 @@ func TestUnmarshalSlicesAndArrays(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, [9]int{1, 2, 3, 4, 5, 6, 7, 8, 9}, v)
 	})
+
+	t.Run("slice with truncated tag", func(t *testing.T) {
+		var v []int
+		err := bipf.NewDecoder(bytes.NewReader(h("80"))).Decode(&v)
+		require.Error(t, err)
+	})
+
+	t.Run("array with truncated tag", func(t *testing.T) {
+		var v [9]int
+		err := bipf.NewDecoder(bytes.NewReader(h("80"))).Decode(&v)
+		require.Error(t, err)
+	})
 }
 
 func TestUnmarshalStructs(t *testing.T) {
@@ -711,239 +757,2580 @@ func TestComplexStructIntoAny(t *testing.T) {
 	require.Empty(t, cmp.Diff(expected, unmarshaled))
 }
 
-func BenchmarkSimpleStruct(b *testing.B) {
-	v := newSimpleStruct()
-	p := newSimpleProtoStruct()
+func TestEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := bipf.NewEncoder(buf)
 
-	jsonBytes, err := json.Marshal(v)
-	require.NoError(b, err)
+	require.NoError(t, enc.Encode("hello"))
+	require.NoError(t, enc.Encode(int32(100)))
+	require.Error(t, enc.Encode(make(chan int)))
+	require.NoError(t, enc.Encode(true))
+	require.Empty(t, buf.Bytes())
 
-	bipfBytes, err := bipf.Marshal(v)
-	require.NoError(b, err)
+	require.NoError(t, enc.Flush())
+	require.Equal(t, "2868656c6c6f22640000000e01", hex.EncodeToString(buf.Bytes()))
+}
 
-	protoBytes, err := proto.Marshal(p)
-	require.NoError(b, err)
+func TestDecoder(t *testing.T) {
+	// use a reader which returns one byte at a time to exercise refilling the
+	// decoder's buffer in the middle of a value
+	dec := bipf.NewDecoder(iotest.OneByteReader(bytes.NewReader(h("2868656c6c6f22640000000e0121deadbeef"))))
 
-	b.Log("jsonBytes", len(jsonBytes))
-	b.Log("bipfBytes", len(bipfBytes))
-	b.Log("protoBytes", len(protoBytes))
+	var s string
+	require.True(t, dec.More())
+	require.NoError(t, dec.Decode(&s))
+	require.Equal(t, "hello", s)
+	require.Equal(t, int64(6), dec.InputOffset())
 
-	b.Run("proto_marshal", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := proto.Marshal(p)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
-	})
+	var i int
+	require.NoError(t, dec.Decode(&i))
+	require.Equal(t, 100, i)
+	require.Equal(t, int64(11), dec.InputOffset())
 
-	b.Run("proto_unmarshal", func(b *testing.B) {
-		var target internal.SimpleProtobuf
-		for i := 0; i < b.N; i++ {
-			err := proto.Unmarshal(protoBytes, &target)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
-	})
+	var v any
+	require.NoError(t, dec.Decode(&v))
+	require.Equal(t, true, v)
 
-	b.Run("json_marshal", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := json.Marshal(v)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
-	})
+	var b []byte
+	require.NoError(t, dec.Decode(&b))
+	require.Equal(t, []byte{0xDE, 0xAD, 0xBE, 0xEF}, b)
 
-	b.Run("json_unmarshal", func(b *testing.B) {
-		var target simpleStruct
-		for i := 0; i < b.N; i++ {
-			err := json.Unmarshal(jsonBytes, &target)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
-	})
+	require.False(t, dec.More())
+	require.ErrorIs(t, dec.Decode(&v), io.EOF)
+}
 
-	b.Run("bipf_marshal", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := bipf.Marshal(v)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
-	})
+func TestDecoderLargeValues(t *testing.T) {
+	v := newComplexStruct()
 
-	b.Run("bipf_unmarshal", func(b *testing.B) {
-		var target simpleStruct
-		for i := 0; i < b.N; i++ {
-			err := bipf.Unmarshal(bipfBytes, &target)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	buf := &bytes.Buffer{}
+	enc := bipf.NewEncoder(buf)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, enc.Encode(v))
+	}
+	require.NoError(t, enc.Flush())
+
+	dec := bipf.NewDecoder(buf)
+	for i := 0; i < 100; i++ {
+		var target complexStruct
+		require.NoError(t, dec.Decode(&target))
+		require.Equal(t, v.HardString, target.HardString)
+	}
+	require.False(t, dec.More())
+
+	t.Run("string_split_across_reads", func(t *testing.T) {
+		s := strings.Repeat("abc", 1000)
+		b, err := bipf.Marshal(s)
+		require.NoError(t, err)
+
+		var target string
+		require.NoError(t, bipf.NewDecoder(iotest.OneByteReader(bytes.NewReader(b))).Decode(&target))
+		require.Equal(t, s, target)
 	})
 }
 
-func BenchmarkComplexStruct(b *testing.B) {
-	v := newComplexStruct()
-	p := newComplexProtoStruct()
+func TestDecoderTruncatedValue(t *testing.T) {
+	dec := bipf.NewDecoder(bytes.NewReader(h("2868656c")))
 
-	jsonBytes, err := json.Marshal(v)
-	require.NoError(b, err)
+	var s string
+	require.ErrorIs(t, dec.Decode(&s), io.ErrUnexpectedEOF)
+}
 
-	bipfBytes, err := bipf.Marshal(v)
-	require.NoError(b, err)
+func TestDecoderDoesNotTrustDeclaredLengths(t *testing.T) {
+	// a STRING tag declaring a 1GiB payload followed by only a few bytes
+	dec := bipf.NewDecoder(bytes.NewReader(h("80808080206869")))
 
-	protoBytes, err := proto.Marshal(p)
-	require.NoError(b, err)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
 
-	b.Log("jsonBytes", len(jsonBytes))
-	b.Log("bipfBytes", len(bipfBytes))
-	b.Log("protoBytes", len(protoBytes))
+	var s string
+	require.ErrorIs(t, dec.Decode(&s), io.ErrUnexpectedEOF)
 
-	b.Run("proto_marshal", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := proto.Marshal(p)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	runtime.ReadMemStats(&after)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func TestSeekKey(t *testing.T) {
+	buf := h("9d031049442201000000204e616d65205265647330436f6c6f7273c401384372696d736f6e185265642052756279304d61726f6f6e")
+
+	offset, err := bipf.SeekKey(buf, 0, "Name")
+	require.NoError(t, err)
+	require.Equal(t, 15, offset)
+
+	offset, err = bipf.SeekKey(buf, 0, "Colors")
+	require.NoError(t, err)
+	require.Equal(t, 27, offset)
+
+	offset, err = bipf.SeekKey(buf, 0, "Missing")
+	require.NoError(t, err)
+	require.Equal(t, -1, offset)
+
+	offset, err = bipf.SeekKey(buf, 15, "Name")
+	require.NoError(t, err)
+	require.Equal(t, -1, offset)
+
+	_, err = bipf.SeekKey(buf[:20], 0, "Colors")
+	require.Error(t, err)
+}
+
+func TestSeeker(t *testing.T) {
+	buf, err := bipf.Marshal(map[string]any{
+		"value": map[string]any{
+			"content": map[string]any{
+				"type":     "post",
+				"mentions": []any{"@a", map[string]any{"link": "@b"}},
+			},
+		},
 	})
+	require.NoError(t, err)
 
-	b.Run("proto_unmarshal", func(b *testing.B) {
-		var target internal.ComplexProtobuf
-		for i := 0; i < b.N; i++ {
-			err := proto.Unmarshal(protoBytes, &target)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	t.Run("array_index", func(t *testing.T) {
+		offset, err := bipf.MustCompilePath("value", "content", "mentions", 1, "link").Seek(buf, 0)
+		require.NoError(t, err)
+
+		var v string
+		require.NoError(t, bipf.Unmarshal(buf[offset:offset+3], &v))
+		require.Equal(t, "@b", v)
 	})
 
-	b.Run("json_marshal", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := json.Marshal(v)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	t.Run("missing_key", func(t *testing.T) {
+		offset, err := bipf.MustCompilePath("value", "missing", "type").Seek(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, -1, offset)
 	})
 
-	b.Run("json_unmarshal", func(b *testing.B) {
-		var target complexStruct
-		for i := 0; i < b.N; i++ {
-			err := json.Unmarshal(jsonBytes, &target)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	t.Run("index_out_of_range", func(t *testing.T) {
+		offset, err := bipf.MustCompilePath("value", "content", "mentions", 2).Seek(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, -1, offset)
 	})
 
-	b.Run("bipf_marshal", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			_, err := bipf.Marshal(v)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	t.Run("not_a_container", func(t *testing.T) {
+		offset, err := bipf.MustCompilePath("value", "content", "type", "foo").Seek(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, -1, offset)
 	})
 
-	b.Run("bipf_unmarshal", func(b *testing.B) {
-		var target complexStruct
-		for i := 0; i < b.N; i++ {
-			err := bipf.Unmarshal(bipfBytes, &target)
-			if err != nil {
-				b.Fatal(err)
-			}
-		}
+	t.Run("invalid_path", func(t *testing.T) {
+		_, err := bipf.CompilePath("value", 1.5)
+		require.Error(t, err)
+
+		_, err = bipf.CompilePath("value", -1)
+		require.Error(t, err)
 	})
 }
 
-type simpleStruct struct {
-	String  string
-	Int64   int64
-	Float64 float64
-	Slice   []string
-	Bytes   []byte
-}
+func TestUnmarshalAt(t *testing.T) {
+	buf := h("9d031049442201000000204e616d65205265647330436f6c6f7273c401384372696d736f6e185265642052756279304d61726f6f6e")
 
-var (
-	vHardString   = "łó\"śð"
-	vSimpleString = "string"
-	vint32        = int32(123)
-	vint64        = int64(123)
-	vfloat32      = float32(1.123)
-	vfloat64      = float64(1.123)
-)
+	var name string
+	require.NoError(t, bipf.UnmarshalAt(buf, 15, &name))
+	require.Equal(t, "Reds", name)
 
-func newSimpleStruct() simpleStruct {
-	return simpleStruct{
-		String:  vSimpleString,
-		Int64:   vint64,
-		Float64: vfloat64,
-		Slice:   []string{vSimpleString},
-		Bytes:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
-	}
+	var colors []string
+	require.NoError(t, bipf.UnmarshalAt(buf, 27, &colors))
+	require.Equal(t, []string{"Crimson", "Red", "Ruby", "Maroon"}, colors)
+
+	require.Error(t, bipf.UnmarshalAt(buf, len(buf), &name))
 }
 
-func newSimpleProtoStruct() *internal.SimpleProtobuf {
-	return &internal.SimpleProtobuf{
-		String_: vSimpleString,
-		Int64:   vint64,
-		Float64: vfloat64,
-		Slice:   []string{vSimpleString},
-		Bytes:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
+func TestUnmarshalPath(t *testing.T) {
+	type content struct {
+		Type string `bipf:"type"`
+		Text string `bipf:"text"`
 	}
+
+	buf, err := bipf.Marshal(map[string]any{
+		"key": "%abc",
+		"value": map[string]any{
+			"author": "@def",
+			"content": content{
+				Type: "post",
+				Text: "hello",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	var c content
+	require.NoError(t, bipf.UnmarshalPath(buf, &c, "value", "content"))
+	require.Equal(t, content{Type: "post", Text: "hello"}, c)
+
+	var text string
+	require.NoError(t, bipf.UnmarshalPath(buf, &text, "value", "content", "text"))
+	require.Equal(t, "hello", text)
+
+	require.Error(t, bipf.UnmarshalPath(buf, &text, "value", "missing"))
 }
 
-type complexStruct struct {
-	SimpleString    string
-	HardString      string
-	SimpleStringPtr *string
-	HardStringPtr   *string
+func TestCompare(t *testing.T) {
+	// values are listed in ascending order
+	values := []any{
+		nil,
+		"",
+		"a",
+		"ab",
+		"b",
+		[]byte{0x00},
+		[]byte{0x01},
+		math.NaN(),
+		math.Inf(-1),
+		-10,
+		-1.5,
+		0,
+		1.5,
+		10,
+		math.Inf(1),
+		[]any{},
+		[]any{1},
+		[]any{1, 2},
+		[]any{2},
+		map[string]any{},
+		map[string]any{"a": 1},
+		map[string]any{"a": 2},
+		map[string]any{"b": 1},
+		false,
+		true,
+	}
 
-	Int32    int32
-	Int64    int64
-	Int32Ptr *int32
-	Int64Ptr *int64
+	for i := range values {
+		for j := range values {
+			a, err := bipf.Marshal(values[i])
+			require.NoError(t, err)
 
-	Float32    float32
-	Float64    float64
-	Float32Ptr *float32
-	Float64Ptr *float64
+			b, err := bipf.Marshal(values[j])
+			require.NoError(t, err)
 
-	Map    map[string]any
-	MapPtr *map[string]any
+			result, err := bipf.Compare(a, 0, b, 0)
+			require.NoError(t, err)
 
-	Slice    []any
-	SlicePtr *[]any
+			switch {
+			case i < j:
+				require.Equal(t, -1, result, "%v < %v", values[i], values[j])
+			case i > j:
+				require.Equal(t, 1, result, "%v > %v", values[i], values[j])
+			default:
+				require.Equal(t, 0, result, "%v == %v", values[i], values[j])
+			}
+		}
+	}
 
-	Bytes    []byte
-	BytesPtr *[]byte
+	t.Run("missing_values_sort_first", func(t *testing.T) {
+		b, err := bipf.Marshal(nil)
+		require.NoError(t, err)
 
-	Struct struct {
-		SimpleString string
-		HardString   string
+		result, err := bipf.Compare(nil, -1, b, 0)
+		require.NoError(t, err)
+		require.Equal(t, -1, result)
+	})
 
-		Int32 int32
-		Int64 int64
+	t.Run("equal_int_and_double", func(t *testing.T) {
+		a, err := bipf.Marshal(5)
+		require.NoError(t, err)
 
-		Float32 float32
-		Float64 float64
+		b, err := bipf.Marshal(5.0)
+		require.NoError(t, err)
 
-		Map    map[string]any
-		MapPtr *map[string]any
+		result, err := bipf.Compare(a, 0, b, 0)
+		require.NoError(t, err)
+		require.Equal(t, 0, result)
+	})
 
-		Slice    []any
-		SlicePtr *[]any
+	t.Run("max_depth", func(t *testing.T) {
+		nestedArrays := func(depth int) []byte {
+			b := []byte{0x04}
+			for i := 1; i < depth; i++ {
+				b = append(binary.AppendUvarint(nil, uint64(len(b))<<3|0x04), b...)
+			}
+			return b
+		}
+
+		b := nestedArrays(10000)
+		result, err := bipf.Compare(b, 0, b, 0)
+		require.NoError(t, err)
+		require.Equal(t, 0, result)
+
+		b = nestedArrays(10001)
+		_, err = bipf.Compare(b, 0, b, 0)
+		var syntaxErr *bipf.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+		require.Equal(t, int64(len(b)-1), syntaxErr.Offset)
+	})
+}
+
+func TestSortByPath(t *testing.T) {
+	type value struct {
+		Timestamp float64 `bipf:"timestamp"`
+	}
+
+	type message struct {
+		Key   string `bipf:"key"`
+		Value *value `bipf:"value"`
+	}
+
+	var records [][]byte
+	for _, m := range []message{
+		{Key: "c", Value: &value{Timestamp: 3}},
+		{Key: "a", Value: &value{Timestamp: 1}},
+		{Key: "none"},
+		{Key: "b", Value: &value{Timestamp: 2}},
+	} {
+		record, err := bipf.Marshal(m)
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+
+	require.NoError(t, bipf.SortByPath(records, "value", "timestamp"))
+
+	var keys []string
+	for _, record := range records {
+		var key string
+		require.NoError(t, bipf.UnmarshalPath(record, &key, "key"))
+		keys = append(keys, key)
+	}
+	require.Equal(t, []string{"none", "a", "b", "c"}, keys)
+
+	t.Run("mixed_ints_and_doubles", func(t *testing.T) {
+		var records [][]byte
+		for _, timestamp := range []any{5, 3.5, math.NaN(), 4, 6.5, 1} {
+			record, err := bipf.Marshal(map[string]any{"value": map[string]any{"timestamp": timestamp}})
+			require.NoError(t, err)
+			records = append(records, record)
+		}
+
+		require.NoError(t, bipf.SortByPath(records, "value", "timestamp"))
+
+		var timestamps []any
+		for _, record := range records {
+			var timestamp any
+			require.NoError(t, bipf.UnmarshalPath(record, &timestamp, "value", "timestamp"))
+			timestamps = append(timestamps, timestamp)
+		}
+		require.Len(t, timestamps, 6)
+		require.True(t, math.IsNaN(timestamps[0].(float64)))
+		require.Equal(t, []any{int32(1), 3.5, int32(4), int32(5), 6.5}, timestamps[1:])
+	})
+}
+
+func TestReader(t *testing.T) {
+	r := bipf.NewReaderBytes(h("9d031049442201000000204e616d65205265647330436f6c6f7273c401384372696d736f6e185265642052756279304d61726f6f6e0e01"))
+
+	kind, length, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, bipf.KindObject, kind)
+	require.Equal(t, 51, length)
+	require.Equal(t, 0, r.Offset())
+
+	kind, err = r.Enter()
+	require.NoError(t, err)
+	require.Equal(t, bipf.KindObject, kind)
+	require.Equal(t, 2, r.Offset())
+
+	key, err := r.ReadString()
+	require.NoError(t, err)
+	require.Equal(t, "ID", key)
+
+	_, err = r.ReadString()
+	require.Error(t, err)
+
+	id, err := r.ReadInt32()
+	require.NoError(t, err)
+	require.Equal(t, int32(1), id)
+
+	key, err = r.ReadString()
+	require.NoError(t, err)
+	require.Equal(t, "Name", key)
+
+	require.NoError(t, r.Skip())
+
+	key, err = r.ReadString()
+	require.NoError(t, err)
+	require.Equal(t, "Colors", key)
+
+	kind, err = r.Enter()
+	require.NoError(t, err)
+	require.Equal(t, bipf.KindArray, kind)
+
+	var colors []string
+	for {
+		_, _, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		color, err := r.ReadString()
+		require.NoError(t, err)
+		colors = append(colors, color)
+	}
+	require.Equal(t, []string{"Crimson", "Red", "Ruby", "Maroon"}, colors)
+
+	require.NoError(t, r.Exit())
+
+	_, _, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	require.NoError(t, r.Exit())
+	require.Equal(t, 53, r.Offset())
+
+	v, err := r.ReadBool()
+	require.NoError(t, err)
+	require.True(t, v)
+
+	_, _, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderReadValAfterNext(t *testing.T) {
+	type color struct {
+		Name string `bipf:"name"`
+	}
+
+	colors := []any{color{Name: "red"}, "skipped", color{Name: strings.Repeat("x", 20)}}
+	b, err := bipf.Marshal(colors)
+	require.NoError(t, err)
+
+	readers := map[string]func() *bipf.Reader{
+		"bytes":           func() *bipf.Reader { return bipf.NewReaderBytes(b) },
+		"reader":          func() *bipf.Reader { return bipf.NewReader(bytes.NewReader(b)) },
+		"one_byte_reader": func() *bipf.Reader { return bipf.NewReader(iotest.OneByteReader(bytes.NewReader(b))) },
+	}
+
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			r := newReader()
+			_, err := r.Enter()
+			require.NoError(t, err)
+
+			var decoded []color
+			for {
+				kind, _, err := r.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+
+				if kind != bipf.KindObject {
+					require.NoError(t, r.Skip())
+					continue
+				}
+
+				var c color
+				require.NoError(t, r.ReadVal(&c))
+				decoded = append(decoded, c)
+			}
+			require.Equal(t, []color{colors[0].(color), colors[2].(color)}, decoded)
+			require.NoError(t, r.Exit())
+			require.Equal(t, len(b), r.Offset())
+		})
+	}
+
+	t.Run("error_offset", func(t *testing.T) {
+		// an ARRAY with a two-byte tag containing an invalid BOOLNULL
+		b := h("d401" + "c401" + "a001" + strings.Repeat("78", 20) + "0e02")
+
+		readVal := func(r *bipf.Reader) error {
+			_, err := r.Enter()
+			require.NoError(t, err)
+
+			_, _, err = r.Next()
+			require.NoError(t, err)
+
+			var v []any
+			return r.ReadVal(&v)
+		}
+
+		expected := readVal(bipf.NewReaderBytes(b))
+		require.Error(t, expected)
+
+		err := readVal(bipf.NewReader(iotest.OneByteReader(bytes.NewReader(b))))
+		require.Equal(t, expected.Error(), err.Error())
+		require.EqualError(t, err, "invalid bool value at offset 28")
+	})
+}
+
+func TestReaderTruncatedInput(t *testing.T) {
+	r := bipf.NewReader(bytes.NewReader(h("9d0310494422")))
+
+	_, err := r.Enter()
+	require.NoError(t, err)
+
+	require.NoError(t, r.Skip())
+
+	_, err = r.ReadInt32()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestConfig(t *testing.T) {
+	type value struct {
+		Name string `bipf:"name" custom:"customName"`
+	}
+
+	v := value{Name: "a"}
+
+	custom := bipf.Config{TagKey: "custom"}.Freeze()
+
+	defaultBytes, err := bipf.Marshal(v)
+	require.NoError(t, err)
+
+	customBytes, err := custom.Marshal(v)
+	require.NoError(t, err)
+
+	require.Equal(t, "3d206e616d650861", hex.EncodeToString(defaultBytes))
+	require.Equal(t, "6d50637573746f6d4e616d650861", hex.EncodeToString(customBytes))
+
+	var target value
+	require.NoError(t, custom.Unmarshal(customBytes, &target))
+	require.Equal(t, v, target)
+
+	t.Run("max_depth", func(t *testing.T) {
+		nested, err := bipf.Marshal([]any{[]any{[]any{}}})
+		require.NoError(t, err)
+
+		var target any
+		require.NoError(t, bipf.Unmarshal(nested, &target))
+		require.NoError(t, bipf.Config{MaxDepth: 2}.Freeze().Unmarshal(nested, &target))
+		require.Error(t, bipf.Config{MaxDepth: 1}.Freeze().Unmarshal(nested, &target))
+	})
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	type value struct {
+		Name string `bipf:"name"`
+	}
+
+	type valueWithExtraField struct {
+		Name  string `bipf:"name"`
+		Extra string `bipf:"extra"`
+	}
+
+	b, err := bipf.Marshal(valueWithExtraField{Name: "a", Extra: "b"})
+	require.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		var target value
+		require.NoError(t, bipf.Unmarshal(b, &target))
+		require.Equal(t, value{Name: "a"}, target)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		var target value
+		err := bipf.Config{DisallowUnknownFields: true}.Freeze().Unmarshal(b, &target)
+		require.EqualError(t, err, `unknown field "extra" at offset 8 in bipf_test.value`)
+	})
+
+	t.Run("decoder", func(t *testing.T) {
+		dec := bipf.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+
+		var target value
+		require.EqualError(t, dec.Decode(&target), `unknown field "extra" at offset 8 in bipf_test.value`)
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		type outer struct {
+			Values []value `bipf:"values"`
+		}
+
+		b, err := bipf.Marshal(map[string]any{"values": []any{value{Name: "a"}, valueWithExtraField{Name: "b", Extra: "c"}}})
+		require.NoError(t, err)
+
+		var target outer
+		err = bipf.Config{DisallowUnknownFields: true}.Freeze().Unmarshal(b, &target)
+
+		var unknownErr *bipf.UnknownFieldError
+		require.ErrorAs(t, err, &unknownErr)
+		require.Equal(t, "extra", unknownErr.Key)
+		require.Equal(t, reflect.TypeOf(value{}), unknownErr.Type)
+		require.Equal(t, int64(bytes.Index(b, []byte("\x28extra"))), unknownErr.Offset)
+		require.Equal(t, "values[1]", unknownErr.Field)
+		require.EqualError(t, err, fmt.Sprintf(`unknown field "extra" at offset %d in field values[1] of type bipf_test.value`, unknownErr.Offset))
+	})
+}
+
+func TestCaseSensitive(t *testing.T) {
+	type value struct {
+		ID string
+	}
+
+	b, err := bipf.Marshal(map[string]string{"id": "a"})
+	require.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		var target value
+		require.NoError(t, bipf.Unmarshal(b, &target))
+		require.Equal(t, value{ID: "a"}, target)
+	})
+
+	t.Run("case_sensitive", func(t *testing.T) {
+		var target value
+		require.NoError(t, bipf.Config{CaseSensitive: true}.Freeze().Unmarshal(b, &target))
+		require.Equal(t, value{}, target)
+	})
+}
+
+func TestDisallowDuplicateFields(t *testing.T) {
+	type value struct {
+		ID string
+	}
+
+	b, err := bipf.Marshal(map[string]string{"id": "a", "ID": "b"})
+	require.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		// {"ID": "a", "iD": "b"}
+		b := h("5510494408611069440862")
+
+		var target value
+		require.NoError(t, bipf.Unmarshal(b, &target))
+		require.Equal(t, value{ID: "b"}, target)
+	})
+
+	t.Run("default_exact_duplicates", func(t *testing.T) {
+		b := h("5510494408611049440862")
+
+		var target value
+		require.NoError(t, bipf.Unmarshal(b, &target))
+		require.Equal(t, value{ID: "b"}, target)
+
+		err := bipf.Config{DisallowDuplicateFields: true}.Freeze().Unmarshal(b, &target)
+		require.EqualError(t, err, `fields "ID" and "ID" at offset 6 both match field ID of bipf_test.value`)
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		type outer struct {
+			Value value `bipf:"value"`
+		}
+
+		// {"value": {"ID": "a", "iD": "b"}}
+		b := h("8d012876616c75655510494408611069440862")
+
+		var target outer
+		err := bipf.Config{DisallowDuplicateFields: true}.Freeze().Unmarshal(b, &target)
+
+		var duplicateErr *bipf.DuplicateFieldError
+		require.ErrorAs(t, err, &duplicateErr)
+		require.Equal(t, &bipf.DuplicateFieldError{
+			PreviousKey: "ID",
+			Key:         "iD",
+			StructField: "ID",
+			Type:        reflect.TypeOf(value{}),
+			Offset:      14,
+			Field:       "value",
+		}, duplicateErr)
+		require.EqualError(t, err, `fields "ID" and "iD" at offset 14 in field value both match field ID of bipf_test.value`)
+	})
+
+	t.Run("disallow_duplicate_fields", func(t *testing.T) {
+		var target value
+		err := bipf.Config{DisallowDuplicateFields: true}.Freeze().Unmarshal(b, &target)
+		require.ErrorContains(t, err, "both match field ID of bipf_test.value")
+	})
+
+	t.Run("disallow_duplicate_fields_case_sensitive", func(t *testing.T) {
+		var target value
+		err := bipf.Config{DisallowDuplicateFields: true, CaseSensitive: true}.Freeze().Unmarshal(b, &target)
+		require.NoError(t, err)
+		require.Equal(t, value{ID: "b"}, target)
+	})
+}
+
+func TestSortMapKeys(t *testing.T) {
+	api := bipf.Config{SortMapKeys: true}.Freeze()
+
+	t.Run("string_keys", func(t *testing.T) {
+		v := map[string]int{"b": 2, "a": 1, "ab": 3, "c": 4}
+
+		for i := 0; i < 10; i++ {
+			b, err := api.Marshal(v)
+			require.NoError(t, err)
+			require.Equal(t, "ed010861220100000010616222030000000862220200000008632204000000", hex.EncodeToString(b))
+		}
+	})
+
+	t.Run("interface_keys", func(t *testing.T) {
+		v := map[any]string{true: "d", 2: "c", "b": "b", 1: "a"}
+
+		b, err := api.Marshal(v)
+		require.NoError(t, err)
+
+		r := bipf.NewReaderBytes(b)
+		_, err = r.Enter()
+		require.NoError(t, err)
+
+		var kinds []bipf.Kind
+		var values []string
+		for {
+			kind, _, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			require.NoError(t, r.Skip())
+			kinds = append(kinds, kind)
+
+			value, err := r.ReadString()
+			require.NoError(t, err)
+			values = append(values, value)
+		}
+
+		require.Equal(t, []bipf.Kind{bipf.KindString, bipf.KindInt, bipf.KindInt, bipf.KindBoolNull}, kinds)
+		require.Equal(t, []string{"b", "a", "c", "d"}, values)
+	})
+
+	t.Run("numerically_equal_keys", func(t *testing.T) {
+		v := map[any]any{1: "x", 1.0: "x", int64(2): "y", 2.5: "z"}
+
+		expected, err := api.Marshal(v)
+		require.NoError(t, err)
+
+		for i := 0; i < 200; i++ {
+			b, err := api.Marshal(v)
+			require.NoError(t, err)
+			require.Equal(t, expected, b)
+		}
+	})
+
+	t.Run("keys_are_encoded_once", func(t *testing.T) {
+		calls := 0
+		v := map[countingKey]int{
+			{name: "b", calls: &calls}: 2,
+			{name: "a", calls: &calls}: 1,
+		}
+
+		b, err := api.Marshal(v)
+		require.NoError(t, err)
+		require.Equal(t, "750961220100000009622202000000", hex.EncodeToString(b))
+		require.Equal(t, 2, calls)
+	})
+}
+
+// countingKey counts how many times it was marshaled.
+type countingKey struct {
+	name  string
+	calls *int
+}
+
+func (k countingKey) MarshalBinary() ([]byte, error) {
+	*k.calls++
+	return []byte(k.name), nil
+}
+
+func TestRegisterTypeCodec(t *testing.T) {
+	bipf.RegisterTypeEncoderFunc("bipf_test.extensionPublicKey", func(ptr unsafe.Pointer, w *bipf.Writer) error {
+		return w.WriteBuffer((*extensionPublicKey)(ptr).key)
+	}, nil)
+	bipf.RegisterTypeDecoderFunc("bipf_test.extensionPublicKey", func(ptr unsafe.Pointer, r *bipf.Reader) error {
+		key, err := r.ReadBuffer()
+		if err != nil {
+			return err
+		}
+		(*extensionPublicKey)(ptr).key = key
+		return nil
+	})
+
+	t.Run("value", func(t *testing.T) {
+		b, err := bipf.Marshal(extensionPublicKey{key: []byte{0x01, 0x02}})
+		require.NoError(t, err)
+		require.Equal(t, "110102", hex.EncodeToString(b))
+
+		var v extensionPublicKey
+		require.NoError(t, bipf.Unmarshal(b, &v))
+		require.Equal(t, []byte{0x01, 0x02}, v.key)
+	})
+
+	t.Run("pointer_in_struct", func(t *testing.T) {
+		type testStruct struct {
+			Key  *extensionPublicKey
+			Keys []extensionPublicKey
+		}
+
+		v := testStruct{
+			Key:  &extensionPublicKey{key: []byte{0x01}},
+			Keys: []extensionPublicKey{{key: []byte{0x02}}},
+		}
+
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+
+		var m map[string]any
+		require.NoError(t, bipf.Unmarshal(b, &m))
+		require.Equal(t, map[string]any{"Key": []byte{0x01}, "Keys": []any{[]byte{0x02}}}, m)
+
+		var decoded testStruct
+		require.NoError(t, bipf.Unmarshal(b, &decoded))
+		require.Equal(t, v, decoded)
+
+		b, err = bipf.Marshal(testStruct{})
+		require.NoError(t, err)
+
+		decoded = testStruct{Key: &extensionPublicKey{}}
+		require.NoError(t, bipf.Unmarshal(b, &decoded))
+		require.Equal(t, testStruct{}, decoded)
+	})
+}
+
+func TestRegisterTypeEncoderWritingInvalidOutput(t *testing.T) {
+	bipf.RegisterTypeEncoderFunc("bipf_test.extensionNoValues", func(ptr unsafe.Pointer, w *bipf.Writer) error {
+		return nil
+	}, nil)
+	bipf.RegisterTypeEncoderFunc("bipf_test.extensionTwoValues", func(ptr unsafe.Pointer, w *bipf.Writer) error {
+		if err := w.WriteInt32(1); err != nil {
+			return err
+		}
+		return w.WriteInt32(2)
+	}, nil)
+
+	_, err := bipf.Marshal([]extensionNoValues{{}})
+	require.Error(t, err)
+
+	_, err = bipf.Marshal([]extensionTwoValues{{}})
+	require.Error(t, err)
+
+	_, err = bipf.EncodingLength(map[string]extensionTwoValues{"a": {}})
+	require.Error(t, err)
+}
+
+func TestRegisterTypeEncoderWritingContainers(t *testing.T) {
+	long := strings.Repeat("a", 300)
+
+	bipf.RegisterTypeEncoderFunc("bipf_test.extensionContainers", func(ptr unsafe.Pointer, w *bipf.Writer) error {
+		return w.WriteArray(func(w *bipf.Writer) error {
+			if err := w.WriteString(long); err != nil {
+				return err
+			}
+			if err := w.WriteObject(func(w *bipf.Writer) error {
+				if err := w.WriteString("b"); err != nil {
+					return err
+				}
+				return w.WriteArray(func(w *bipf.Writer) error {
+					if err := w.WriteInt32(1); err != nil {
+						return err
+					}
+					return w.WriteVal(map[string]any{"c": []any{long}})
+				})
+			}); err != nil {
+				return err
+			}
+			return w.WriteArray(func(w *bipf.Writer) error {
+				return nil
+			})
+		})
+	}, nil)
+
+	expected, err := bipf.Marshal([]any{[]any{long, map[string]any{"b": []any{int32(1), map[string]any{"c": []any{long}}}}, []any{}}, "d"})
+	require.NoError(t, err)
+
+	b, err := bipf.Marshal([]any{extensionContainers{}, "d"})
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(b))
+
+	length, err := bipf.EncodingLength([]any{extensionContainers{}, "d"})
+	require.NoError(t, err)
+	require.Equal(t, len(expected), length)
+}
+
+func TestRegisterFieldCodec(t *testing.T) {
+	bipf.RegisterFieldEncoderFunc("bipf_test.extensionFieldStruct", "Secret", func(ptr unsafe.Pointer, w *bipf.Writer) error {
+		return w.WriteInt32(int32(len(*(*string)(ptr))))
+	}, func(ptr unsafe.Pointer) bool {
+		return *(*string)(ptr) == ""
+	})
+	bipf.RegisterFieldDecoderFunc("bipf_test.extensionFieldStruct", "Secret", func(ptr unsafe.Pointer, r *bipf.Reader) error {
+		length, err := r.ReadInt32()
+		if err != nil {
+			return err
+		}
+		*(*string)(ptr) = strings.Repeat("*", int(length))
+		return nil
+	})
+
+	b, err := bipf.Marshal(extensionFieldStruct{Name: "name", Secret: "secret", Other: "other"})
+	require.NoError(t, err)
+
+	var m map[string]any
+	require.NoError(t, bipf.Unmarshal(b, &m))
+	require.Equal(t, map[string]any{"Name": "name", "Secret": int32(6), "Other": "other"}, m)
+
+	var v extensionFieldStruct
+	require.NoError(t, bipf.Unmarshal(b, &v))
+	require.Equal(t, extensionFieldStruct{Name: "name", Secret: "******", Other: "other"}, v)
+
+	b, err = bipf.Marshal(extensionFieldStruct{Name: "name"})
+	require.NoError(t, err)
+
+	m = nil
+	require.NoError(t, bipf.Unmarshal(b, &m))
+	require.Equal(t, map[string]any{"Name": "name", "Other": ""}, m)
+}
+
+func TestExtension(t *testing.T) {
+	api := bipf.Config{}.Freeze()
+	api.RegisterExtension(&testExtension{})
+
+	type testStruct struct {
+		Duration time.Duration
+		Other    string
+	}
+
+	v := testStruct{Duration: 90 * time.Second, Other: "other"}
+
+	b, err := api.Marshal(v)
+	require.NoError(t, err)
+
+	expected, err := bipf.Marshal(map[string]any{"duration": map[string]any{"value": "1m30s"}})
+	require.NoError(t, err)
+	require.Equal(t, expected, b)
+
+	var decoded testStruct
+	require.NoError(t, api.Unmarshal(b, &decoded))
+	require.Equal(t, testStruct{Duration: v.Duration}, decoded)
+
+	t.Run("other_apis_are_not_affected", func(t *testing.T) {
+		b, err := bipf.Marshal(struct{ Other string }{Other: "other"})
+		require.NoError(t, err)
+
+		var m map[string]any
+		require.NoError(t, bipf.Unmarshal(b, &m))
+		require.Equal(t, map[string]any{"Other": "other"}, m)
+	})
+
+	t.Run("derived_apis_use_extensions_registered_later", func(t *testing.T) {
+		api := bipf.Config{}.Freeze()
+
+		dec := api.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+
+		api.RegisterExtension(&testExtension{})
+
+		var decoded testStruct
+		require.NoError(t, dec.Decode(&decoded))
+		require.Equal(t, testStruct{Duration: v.Duration}, decoded)
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		var decoded testStruct
+		require.NoError(t, api.NewReaderBytes(b).ReadVal(&decoded))
+		require.Equal(t, testStruct{Duration: v.Duration}, decoded)
+
+		decoded = testStruct{}
+		require.NoError(t, api.NewReader(bytes.NewReader(b)).ReadVal(&decoded))
+		require.Equal(t, testStruct{Duration: v.Duration}, decoded)
+	})
+
+	t.Run("seeker", func(t *testing.T) {
+		seeker, err := bipf.CompilePath("duration")
+		require.NoError(t, err)
+
+		var d time.Duration
+		require.NoError(t, api.UnmarshalSeek(b, seeker, &d))
+		require.Equal(t, v.Duration, d)
+	})
+}
+
+func TestRawMessage(t *testing.T) {
+	type message struct {
+		Type    string
+		Content bipf.RawMessage
+	}
+
+	type content struct {
+		Type    string
+		Content map[string]any
+	}
+
+	original, err := bipf.Marshal(content{
+		Type:    "post",
+		Content: map[string]any{"text": "hello", "root": nil, "mentions": []any{"a", "b"}},
+	})
+	require.NoError(t, err)
+
+	t.Run("round_trip", func(t *testing.T) {
+		var v message
+		require.NoError(t, bipf.Unmarshal(original, &v))
+		require.Equal(t, "post", v.Type)
+
+		offset, err := bipf.SeekKey(original, 0, "Content")
+		require.NoError(t, err)
+		require.Equal(t, bipf.RawMessage(original[offset:]), v.Content)
+
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+		require.Equal(t, original, b)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		dec := bipf.NewDecoder(iotest.OneByteReader(bytes.NewReader(append(original, original...))))
+		for i := 0; i < 2; i++ {
+			var v bipf.RawMessage
+			require.NoError(t, dec.Decode(&v))
+			require.Equal(t, bipf.RawMessage(original), v)
+		}
+	})
+
+	t.Run("null", func(t *testing.T) {
+		var v bipf.RawMessage
+		require.NoError(t, bipf.Unmarshal(h("06"), &v))
+		require.Equal(t, bipf.RawMessage(h("06")), v)
+
+		b, err := bipf.Marshal(message{Type: "post"})
+		require.NoError(t, err)
+
+		var m message
+		require.NoError(t, bipf.Unmarshal(b, &m))
+		require.Equal(t, bipf.RawMessage(h("06")), m.Content)
+
+		b, err = bipf.Marshal(struct {
+			Content bipf.RawMessage `bipf:",omitempty"`
+		}{})
+		require.NoError(t, err)
+		require.Equal(t, "05", hex.EncodeToString(b))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := bipf.Marshal(bipf.RawMessage(h("0861")))
+		require.NoError(t, err)
+
+		_, err = bipf.Marshal(bipf.RawMessage(h("10")))
+		require.Error(t, err)
+
+		_, err = bipf.Marshal(bipf.RawMessage(h("0606")))
+		require.Error(t, err)
+
+		var v bipf.RawMessage
+		require.Error(t, bipf.Unmarshal(h("1061"), &v))
+	})
+}
+
+func TestMarshaler(t *testing.T) {
+	type testStruct struct {
+		Point  marshalerPoint
+		Points []*marshalerPoint
+	}
+
+	t.Run("round_trip", func(t *testing.T) {
+		v := testStruct{
+			Point:  marshalerPoint{X: 1, Y: 2},
+			Points: []*marshalerPoint{{X: 3, Y: 4}, nil},
+		}
+
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+
+		var point []int32
+		require.NoError(t, bipf.UnmarshalPath(b, &point, "Point"))
+		require.Equal(t, []int32{1, 2}, point)
+
+		offset, err := bipf.MustCompilePath("Points", 1).Seek(b, 0)
+		require.NoError(t, err)
+		require.Equal(t, byte(0x06), b[offset])
+
+		var decoded testStruct
+		require.NoError(t, bipf.Unmarshal(b, &decoded))
+		require.Equal(t, v, decoded)
+	})
+
+	t.Run("unmarshaler_receives_entire_value", func(t *testing.T) {
+		for _, s := range []string{"06", "0861", "3c0e012202000000"} {
+			var v marshalerRecorder
+			require.NoError(t, bipf.Unmarshal(h(s), &v))
+			require.Equal(t, s, hex.EncodeToString(v))
+
+			b, err := bipf.Marshal(v)
+			require.NoError(t, err)
+			require.Equal(t, s, hex.EncodeToString(b))
+		}
+	})
+
+	t.Run("invalid_marshaler_output", func(t *testing.T) {
+		for _, s := range []string{"", "10", "08610861", "2202", "1c0861", "0e0101"} {
+			_, err := bipf.Marshal(marshalerRecorder(h(s)))
+			require.Error(t, err, s)
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		api := bipf.Config{LegacyMarshalers: true}.Freeze()
+
+		var v marshalerRecorder
+		require.NoError(t, api.Unmarshal(h("3c0e012202000000"), &v))
+		require.Equal(t, "0e012202000000", hex.EncodeToString(v))
+
+		b, err := api.Marshal(marshalerRecorder(h("08610861")))
+		require.NoError(t, err)
+		require.Equal(t, "08610861", hex.EncodeToString(b))
+	})
+}
+
+func TestExtended(t *testing.T) {
+	bipf.RegisterExtendedType(100,
+		func(v extendedTimestamp) ([]byte, error) {
+			return binary.LittleEndian.AppendUint64(nil, uint64(v.Millis)), nil
+		},
+		func(b []byte) (extendedTimestamp, error) {
+			if len(b) != 8 {
+				return extendedTimestamp{}, errors.New("invalid length")
+			}
+			return extendedTimestamp{Millis: int64(binary.LittleEndian.Uint64(b))}, nil
+		},
+	)
+
+	t.Run("registered", func(t *testing.T) {
+		v := extendedTimestamp{Millis: 1}
+
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+		require.Equal(t, "4f640100000000000000", hex.EncodeToString(b))
+
+		var decoded extendedTimestamp
+		require.NoError(t, bipf.Unmarshal(b, &decoded))
+		require.Equal(t, v, decoded)
+
+		var decodedAny any
+		require.NoError(t, bipf.Unmarshal(b, &decodedAny))
+		require.Equal(t, v, decodedAny)
+
+		type testStruct struct {
+			Timestamp *extendedTimestamp
+		}
+
+		b, err = bipf.Marshal(testStruct{Timestamp: &v})
+		require.NoError(t, err)
+
+		var decodedStruct testStruct
+		require.NoError(t, bipf.Unmarshal(b, &decodedStruct))
+		require.Equal(t, testStruct{Timestamp: &v}, decodedStruct)
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		var v any
+		require.NoError(t, bipf.Unmarshal(h("27c8010102"), &v))
+		require.Equal(t, bipf.Extended{Type: 200, Data: []byte{0x01, 0x02}}, v)
+
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+		require.Equal(t, "27c8010102", hex.EncodeToString(b))
+
+		r := bipf.NewReaderBytes(b)
+		subtype, data, err := r.ReadExtended()
+		require.NoError(t, err)
+		require.Equal(t, uint64(200), subtype)
+		require.Equal(t, []byte{0x01, 0x02}, data)
+	})
+
+	t.Run("wrong_subtype", func(t *testing.T) {
+		var v extendedTimestamp
+		require.Error(t, bipf.Unmarshal(h("27c8010102"), &v))
+	})
+
+	t.Run("integer_policy_doesnt_reserve_subtypes", func(t *testing.T) {
+		bipf.RegisterExtendedType(1,
+			func(v extendedFlag) ([]byte, error) { return []byte{byte(v)}, nil },
+			func(b []byte) (extendedFlag, error) { return extendedFlag(b[0]), nil },
+		)
+
+		var v any
+		require.NoError(t, bipf.Unmarshal(h("17010a"), &v))
+		require.Equal(t, extendedFlag(10), v)
+	})
+
+	t.Run("registered_per_api", func(t *testing.T) {
+		flags := bipf.Config{}.Freeze()
+		flags.RegisterExtendedType(bipf.NewExtendedType(100,
+			func(v extendedFlag) ([]byte, error) { return []byte{byte(v)}, nil },
+			func(b []byte) (extendedFlag, error) { return extendedFlag(b[0]), nil },
+		))
+
+		strs := bipf.Config{}.Freeze()
+		strs.RegisterExtendedType(bipf.NewExtendedType(100,
+			func(v string) ([]byte, error) { return []byte(v), nil },
+			func(b []byte) (string, error) { return string(b), nil },
+		))
+
+		b := h("4f640100000000000000")
+
+		var v any
+		require.NoError(t, bipf.Unmarshal(b, &v))
+		require.Equal(t, extendedTimestamp{Millis: 1}, v)
+
+		require.NoError(t, flags.Unmarshal(h("176405"), &v))
+		require.Equal(t, extendedFlag(5), v)
+
+		require.NoError(t, strs.Unmarshal(b, &v))
+		require.Equal(t, "\x01\x00\x00\x00\x00\x00\x00\x00", v)
+
+		require.NoError(t, bipf.Config{}.Freeze().Unmarshal(b, &v))
+		require.Equal(t, bipf.Extended{Type: 100, Data: h("0100000000000000")}, v)
+
+		dec := flags.NewDecoder(bytes.NewReader(h("176405")))
+		dec.DisallowUnknownFields()
+		require.NoError(t, dec.Decode(&v))
+		require.Equal(t, extendedFlag(5), v)
+
+		encoded, err := flags.Marshal(extendedFlag(5))
+		require.NoError(t, err)
+		require.Equal(t, "176405", hex.EncodeToString(encoded))
+	})
+
+	t.Run("duplicate_registration", func(t *testing.T) {
+		require.Panics(t, func() {
+			bipf.RegisterExtendedType(101, func(v extendedTimestamp) ([]byte, error) { return nil, nil }, func(b []byte) (extendedTimestamp, error) { return extendedTimestamp{}, nil })
+		})
+		require.Panics(t, func() {
+			bipf.RegisterExtendedType(100, func(v string) ([]byte, error) { return nil, nil }, func(b []byte) (string, error) { return "", nil })
+		})
+	})
+}
+
+func TestIntegerPolicy(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		_, err := bipf.Marshal(int64(math.MaxInt32 + 1))
+		require.Error(t, err)
+
+		_, err = bipf.Marshal(int64(math.MinInt32 - 1))
+		require.Error(t, err)
+
+		_, err = bipf.Marshal(uint32(math.MaxInt32 + 1))
+		require.Error(t, err)
+
+		b, err := bipf.Marshal(int64(math.MinInt32))
+		require.NoError(t, err)
+		require.Equal(t, "2200000080", hex.EncodeToString(b))
+	})
+
+	t.Run("double", func(t *testing.T) {
+		api := bipf.Config{IntegerPolicy: bipf.IntegerPolicyDouble}.Freeze()
+
+		b, err := api.Marshal(int64(1 << 40))
+		require.NoError(t, err)
+		require.Equal(t, "430000000000007042", hex.EncodeToString(b))
+
+		var i int64
+		require.NoError(t, api.Unmarshal(b, &i))
+		require.Equal(t, int64(1<<40), i)
+
+		var u uint64
+		require.NoError(t, api.Unmarshal(b, &u))
+		require.Equal(t, uint64(1<<40), u)
+
+		b, err = api.Marshal(uint32(math.MaxUint32))
+		require.NoError(t, err)
+
+		var u32 uint32
+		require.NoError(t, api.Unmarshal(b, &u32))
+		require.Equal(t, uint32(math.MaxUint32), u32)
+
+		b, err = api.Marshal(int64(math.MinInt64))
+		require.NoError(t, err)
+		require.NoError(t, api.Unmarshal(b, &i))
+		require.Equal(t, int64(math.MinInt64), i)
+
+		_, err = api.Marshal(int64(1<<53 + 1))
+		require.Error(t, err)
+
+		_, err = api.Marshal(uint64(math.MaxUint64))
+		require.Error(t, err)
+
+		b, err = api.Marshal(int32(1))
+		require.NoError(t, err)
+		require.Equal(t, "2201000000", hex.EncodeToString(b))
+	})
+
+	t.Run("extended", func(t *testing.T) {
+		api := bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 10, Uint64ExtendedType: 11}.Freeze()
+
+		b, err := api.Marshal(int64(math.MinInt64))
+		require.NoError(t, err)
+		require.Equal(t, "4f0a0000000000000080", hex.EncodeToString(b))
+
+		var i int64
+		require.NoError(t, api.Unmarshal(b, &i))
+		require.Equal(t, int64(math.MinInt64), i)
+
+		var v any
+		require.NoError(t, api.Unmarshal(b, &v))
+		require.Equal(t, int64(math.MinInt64), v)
+
+		b, err = api.Marshal(uint64(math.MaxUint64))
+		require.NoError(t, err)
+		require.Equal(t, "4f0bffffffffffffffff", hex.EncodeToString(b))
+
+		var u uint64
+		require.NoError(t, api.Unmarshal(b, &u))
+		require.Equal(t, uint64(math.MaxUint64), u)
+
+		require.NoError(t, api.Unmarshal(b, &v))
+		require.Equal(t, uint64(math.MaxUint64), v)
+
+		require.Error(t, api.Unmarshal(b, &i))
+
+		// other APIs don't treat the subtypes as integers
+		require.Error(t, bipf.Unmarshal(b, &u))
+		require.NoError(t, bipf.Unmarshal(b, &v))
+		require.Equal(t, bipf.Extended{Type: 11, Data: h("ffffffffffffffff")}, v)
+	})
+
+	t.Run("extended_with_equal_subtypes", func(t *testing.T) {
+		api := bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended}.Freeze()
+
+		_, err := api.Marshal(int64(math.MinInt64))
+		require.Error(t, err)
+
+		b, err := api.Marshal(int64(1))
+		require.NoError(t, err)
+		require.Equal(t, "2201000000", hex.EncodeToString(b))
+	})
+
+	t.Run("decoding", func(t *testing.T) {
+		var i int64
+		require.Error(t, bipf.Unmarshal(h("43000000000000f83f"), &i))
+
+		api := bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 1, Uint64ExtendedType: 2}.Freeze()
+
+		var u uint64
+		require.Error(t, bipf.Unmarshal(h("22ffffffff"), &u))
+		require.Error(t, api.Unmarshal(h("4f01ffffffffffffffff"), &u))
+
+		var u32 uint32
+		require.Error(t, bipf.Unmarshal(h("22ffffffff"), &u32))
+		require.Error(t, api.Unmarshal(h("4f02ffffffffffffffff"), &u32))
+
+		var i32 int32
+		require.Error(t, bipf.Unmarshal(h("430000000000007042"), &i32))
+	})
+}
+
+func TestInterfaceRepresentation(t *testing.T) {
+	b, err := bipf.Marshal(map[string]any{"a": int32(1), "b": nil, "c": map[string]any{"d": 1.5}})
+	require.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		var v any
+		require.NoError(t, bipf.Unmarshal(b, &v))
+		require.Equal(t, map[any]any{"a": int32(1), "b": nil, "c": map[any]any{"d": 1.5}}, v)
+
+		var m map[string]any
+		require.NoError(t, bipf.Unmarshal(b, &m))
+		require.Equal(t, map[string]any{"a": int32(1), "b": nil, "c": map[any]any{"d": 1.5}}, m)
+	})
+
+	t.Run("unhashable_keys", func(t *testing.T) {
+		b, err := bipf.Marshal(map[string]any{"a": 1})
+		require.NoError(t, err)
+		b[1] = 0x09
+
+		var v any
+		require.Error(t, bipf.Unmarshal(b, &v))
+	})
+
+	t.Run("string_map", func(t *testing.T) {
+		api := bipf.Config{ObjectRepresentation: bipf.ObjectRepresentationStringMap, IntRepresentation: bipf.IntRepresentationInt}.Freeze()
+
+		var v any
+		require.NoError(t, api.Unmarshal(b, &v))
+		require.Equal(t, map[string]any{"a": 1, "b": nil, "c": map[string]any{"d": 1.5}}, v)
+
+		j, err := json.Marshal(v)
+		require.NoError(t, err)
+		require.Equal(t, `{"a":1,"b":null,"c":{"d":1.5}}`, string(j))
+
+		nonStringKeys, err := bipf.Marshal(map[int32]string{1: "a"})
+		require.NoError(t, err)
+		require.Error(t, api.Unmarshal(nonStringKeys, &v))
+	})
+
+	t.Run("string_map_stringify_keys", func(t *testing.T) {
+		api := bipf.Config{ObjectRepresentation: bipf.ObjectRepresentationStringMapStringifyKeys, IntRepresentation: bipf.IntRepresentationInt64}.Freeze()
+
+		nonStringKeys, err := bipf.Marshal(map[int32]int32{1: 2})
+		require.NoError(t, err)
+
+		var v any
+		require.NoError(t, api.Unmarshal(nonStringKeys, &v))
+		require.Equal(t, map[string]any{"1": int64(2)}, v)
+	})
+
+	t.Run("use_number", func(t *testing.T) {
+		api := bipf.Config{UseNumber: true}.Freeze()
+
+		b, err := bipf.Marshal([]any{int32(1), 1.0})
+		require.NoError(t, err)
+
+		var v any
+		require.NoError(t, api.Unmarshal(b, &v))
+		require.Equal(t, []any{bipf.IntNumber(1), bipf.DoubleNumber(1)}, v)
+
+		n := v.([]any)[1].(bipf.Number)
+		require.Equal(t, bipf.KindDouble, n.Kind())
+		require.Equal(t, "1.0", n.String())
+		i, err := n.Int64()
+		require.NoError(t, err)
+		require.Equal(t, int64(1), i)
+		f, err := n.Float64()
+		require.NoError(t, err)
+		require.Equal(t, 1.0, f)
+
+		jsonBytes, err := json.Marshal(map[string]any{"a": v.([]any)[0], "b": n})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"a":1,"b":1.0}`, string(jsonBytes))
+
+		var fromJSON struct{ A, B json.Number }
+		require.NoError(t, json.Unmarshal(jsonBytes, &fromJSON))
+		require.Equal(t, json.Number("1"), fromJSON.A)
+		require.Equal(t, json.Number("1.0"), fromJSON.B)
+
+		_, err = json.Marshal(bipf.DoubleNumber(math.NaN()))
+		require.Error(t, err)
+
+		encoded, err := bipf.Marshal(v)
+		require.NoError(t, err)
+		require.Equal(t, b, encoded)
+
+		dec := bipf.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		require.NoError(t, dec.Decode(&v))
+		require.Equal(t, []any{bipf.IntNumber(1), bipf.DoubleNumber(1)}, v)
+
+		var numbers struct {
+			A bipf.Number
+			B *bipf.Number
+			C bipf.Number `bipf:",omitempty"`
+		}
+		numbers.A = bipf.DoubleNumber(1.5)
+		numbers.B = p(bipf.IntNumber(2))
+
+		encoded, err = bipf.Marshal(numbers)
+		require.NoError(t, err)
+
+		var m map[string]any
+		require.NoError(t, bipf.Unmarshal(encoded, &m))
+		require.Equal(t, map[string]any{"A": 1.5, "B": int32(2)}, m)
+
+		numbers.A = ""
+		numbers.B = nil
+		require.NoError(t, bipf.Unmarshal(encoded, &numbers))
+		require.Equal(t, bipf.DoubleNumber(1.5), numbers.A)
+		require.Equal(t, p(bipf.IntNumber(2)), numbers.B)
+
+		_, err = bipf.Marshal(bipf.Number("abc"))
+		require.Error(t, err)
+
+		encoded, err = bipf.Marshal([]bipf.Number{"", "3000000000", "-0"})
+		require.NoError(t, err)
+		require.NoError(t, bipf.Unmarshal(encoded, &v))
+		require.Equal(t, []any{int32(0), 3e9, int32(0)}, v)
+	})
+}
+
+func TestToJSON(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Value    any
+		Expected string
+	}{
+		{Name: "string", Value: "a\"b\\c\n\x01ą", Expected: `"a\"b\\c\n\u0001ą"`},
+		{Name: "int", Value: int32(-123), Expected: `-123`},
+		{Name: "double", Value: 1.5, Expected: `1.5`},
+		{Name: "small_double", Value: 1e-9, Expected: `1e-9`},
+		{Name: "null", Value: nil, Expected: `null`},
+		{Name: "bools", Value: []bool{true, false}, Expected: `[true,false]`},
+		{Name: "buffer", Value: []byte{1, 2, 3}, Expected: `"AQID"`},
+		{Name: "empty_array", Value: []any{}, Expected: `[]`},
+		{Name: "empty_object", Value: map[string]any{}, Expected: `{}`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			b, err := bipf.Marshal(testCase.Value)
+			require.NoError(t, err)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, bipf.ToJSON(buf, b))
+			require.Equal(t, testCase.Expected, buf.String())
+		})
+	}
+
+	t.Run("key_order", func(t *testing.T) {
+		// {"b": 1, "a": [null, "c"]}
+		b := h("6d0862220100000008611c060863")
+		buf := &bytes.Buffer{}
+		require.NoError(t, bipf.ToJSON(buf, b))
+		require.Equal(t, `{"b":1,"a":[null,"c"]}`, buf.String())
+	})
+
+	t.Run("ssb_buffer", func(t *testing.T) {
+		api := bipf.Config{JSONBufferRepresentation: bipf.BufferRepresentationSSB}.Freeze()
+
+		b, err := bipf.Marshal([]byte{1, 2, 3})
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, api.ToJSON(buf, b))
+		require.Equal(t, `"AQID.sha256"`, buf.String())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		nonStringKey, err := bipf.Marshal(map[int32]string{1: "a"})
+		require.NoError(t, err)
+
+		nan, err := bipf.Marshal(math.NaN())
+		require.NoError(t, err)
+
+		extended, err := bipf.Marshal(bipf.Extended{Type: 100})
+		require.NoError(t, err)
+
+		int64Extended, err := bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 1, Uint64ExtendedType: 2}.Freeze().Marshal(int64(1 << 40))
+		require.NoError(t, err)
+
+		for _, b := range [][]byte{nonStringKey, nan, extended, int64Extended, h("0a6161"), h("0600")} {
+			require.Error(t, bipf.ToJSON(io.Discard, b), hex.EncodeToString(b))
+		}
+	})
+
+	t.Run("extended_integers", func(t *testing.T) {
+		api := bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 1, Uint64ExtendedType: 2}.Freeze()
+
+		b, err := api.Marshal([]any{int64(-1 << 40), uint64(math.MaxUint64)})
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, api.ToJSON(buf, b))
+		require.Equal(t, `[-1099511627776,18446744073709551615]`, buf.String())
+
+		b, err = api.Marshal(bipf.Extended{Type: 3})
+		require.NoError(t, err)
+		require.Error(t, api.ToJSON(io.Discard, b))
+	})
+}
+
+func TestFromJSON(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		JSON     string
+		Expected any
+	}{
+		{Name: "string", JSON: `"a\"b\u0001"`, Expected: "a\"b\x01"},
+		{Name: "int", JSON: `-123`, Expected: int32(-123)},
+		{Name: "max_int", JSON: `2147483647`, Expected: int32(math.MaxInt32)},
+		{Name: "double", JSON: `1.0`, Expected: 1.0},
+		{Name: "exponent", JSON: `1e3`, Expected: 1000.0},
+		{Name: "negative_zero", JSON: `-0`, Expected: math.Copysign(0, -1)},
+		{Name: "zero", JSON: `0`, Expected: int32(0)},
+		{Name: "escapes", JSON: `"\/\b\f\n\r\t\u00e9\ud83d\ude00"`, Expected: "/\b\f\n\r\té😀"},
+		{Name: "invalid_surrogates", JSON: `"\ud83d\ud83d\ude00\ude00\ud83dx"`, Expected: "\ufffd😀\ufffd\ufffdx"},
+		{Name: "invalid_utf8", JSON: "\"a\xffb\"", Expected: "a\ufffdb"},
+		{Name: "whitespace", JSON: " \t\r\n[ 1 , { \"a\" : 2 } ]\n", Expected: []any{int32(1), map[string]any{"a": int32(2)}}},
+		{Name: "null", JSON: `null`, Expected: nil},
+		{Name: "bools", JSON: `[true, false]`, Expected: []bool{true, false}},
+		{Name: "base64_string", JSON: `"AQID.sha256"`, Expected: "AQID.sha256"},
+		{Name: "nested", JSON: `{"a": [1, {"b": {}}]}`, Expected: map[string]any{"a": []any{int32(1), map[string]any{"b": map[string]any{}}}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			expected, err := bipf.Marshal(testCase.Expected)
+			require.NoError(t, err)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, bipf.FromJSON(buf, strings.NewReader(testCase.JSON)))
+			require.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(buf.Bytes()))
+		})
+	}
+
+	t.Run("key_order", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, bipf.FromJSON(buf, strings.NewReader(`{"b": 1, "a": [null, "c"]}`)))
+		require.Equal(t, h("6d0862220100000008611c060863"), buf.Bytes())
+	})
+
+	t.Run("ssb_buffer", func(t *testing.T) {
+		api := bipf.Config{JSONBufferRepresentation: bipf.BufferRepresentationSSB}.Freeze()
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, api.FromJSON(buf, strings.NewReader(`["AQID.sha256", "AQID", "!.sha256"]`)))
+
+		expected, err := bipf.Marshal([]any{[]byte{1, 2, 3}, "AQID", "!.sha256"})
+		require.NoError(t, err)
+		require.Equal(t, expected, buf.Bytes())
+	})
+
+	t.Run("round_trip", func(t *testing.T) {
+		api := bipf.Config{JSONBufferRepresentation: bipf.BufferRepresentationSSB}.Freeze()
+		j := `{"key":"%Zm9v.sha256","value":{"previous":null,"sequence":2,"content":{"type":"post","text":"héllo\n","ratio":0.25,"blob":"AAECAw==.sha256"},"tags":[true,false,-1]}}`
+
+		b := &bytes.Buffer{}
+		require.NoError(t, api.FromJSON(b, strings.NewReader(j)))
+
+		out := &bytes.Buffer{}
+		require.NoError(t, api.ToJSON(out, b.Bytes()))
+		require.Equal(t, j, out.String())
+	})
+
+	t.Run("one_byte_reader", func(t *testing.T) {
+		j := `{"a": [1, -0, 1.5, "b\u00e9\ud83d\ude00", {"c": null}], "d": true}`
+
+		expected := &bytes.Buffer{}
+		require.NoError(t, bipf.FromJSON(expected, strings.NewReader(j)))
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, bipf.FromJSON(buf, iotest.OneByteReader(strings.NewReader(j))))
+		require.Equal(t, expected.Bytes(), buf.Bytes())
+	})
+
+	t.Run("deeply_nested", func(t *testing.T) {
+		var v any = "a"
+		for i := 0; i < 500; i++ {
+			v = []any{map[string]any{"k": v}, strings.Repeat("x", i)}
+		}
+		expected, err := bipf.Marshal(v)
+		require.NoError(t, err)
+
+		j, err := json.Marshal(v)
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, bipf.FromJSON(buf, bytes.NewReader(j)))
+		require.Equal(t, expected, buf.Bytes())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, j := range []string{
+			``, ` `, `{`, `[1,]`, `1 2`, `{"a" 1}`, `{"a":1,}`, `[1 2]`, `{1:2}`,
+			`01`, `1.`, `-`, `1e`, `+1`, `.5`, `1e400`,
+			`tru`, `nul`, `falsy`,
+			`"a`, "\"\x01\"", `"\q"`, `"\u12"`, `"\u12g4"`,
+		} {
+			require.Error(t, bipf.FromJSON(io.Discard, strings.NewReader(j)), j)
+		}
+	})
+
+	t.Run("integer_policy", func(t *testing.T) {
+		double := bipf.Config{IntegerPolicy: bipf.IntegerPolicyDouble}.Freeze()
+		extended := bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 1, Uint64ExtendedType: 2}.Freeze()
+
+		testCases := []struct {
+			Name     string
+			API      bipf.API
+			JSON     string
+			Expected any
+		}{
+			{Name: "double", API: double, JSON: `2147483648`, Expected: float64(math.MaxInt32 + 1)},
+			{Name: "double_exact", API: double, JSON: `-9007199254740992`, Expected: float64(-1 << 53)},
+			{Name: "extended_int64", API: extended, JSON: `-9007199254740993`, Expected: int64(-1<<53 - 1)},
+			{Name: "extended_uint64", API: extended, JSON: `18446744073709551615`, Expected: uint64(math.MaxUint64)},
+			{Name: "extended_small", API: extended, JSON: `-2147483648`, Expected: int32(math.MinInt32)},
+		}
+
+		for _, testCase := range testCases {
+			t.Run(testCase.Name, func(t *testing.T) {
+				expected, err := testCase.API.Marshal(testCase.Expected)
+				require.NoError(t, err)
+
+				buf := &bytes.Buffer{}
+				require.NoError(t, testCase.API.FromJSON(buf, strings.NewReader(testCase.JSON)))
+				require.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(buf.Bytes()))
+
+				out := &bytes.Buffer{}
+				require.NoError(t, testCase.API.ToJSON(out, buf.Bytes()))
+				require.Equal(t, testCase.JSON, out.String())
+			})
+		}
+
+		t.Run("errors", func(t *testing.T) {
+			var valueErr *bipf.UnsupportedValueError
+			require.ErrorAs(t, bipf.FromJSON(io.Discard, strings.NewReader(`2147483648`)), &valueErr)
+			require.ErrorAs(t, double.FromJSON(io.Discard, strings.NewReader(`9007199254740993`)), &valueErr)
+			require.ErrorAs(t, double.FromJSON(io.Discard, strings.NewReader(`[1, -9007199254740993]`)), &valueErr)
+
+			for _, api := range []bipf.API{bipf.ConfigDefault, double, extended} {
+				require.Error(t, api.FromJSON(io.Discard, strings.NewReader(`18446744073709551616`)))
+				require.Error(t, api.FromJSON(io.Discard, strings.NewReader(`-9223372036854775809`)))
+			}
+		})
+	})
+
+	t.Run("max_depth", func(t *testing.T) {
+		api := bipf.Config{MaxDepth: 2}.Freeze()
+		require.NoError(t, api.FromJSON(io.Discard, strings.NewReader(`[[]]`)))
+		require.Error(t, api.FromJSON(io.Discard, strings.NewReader(`[[[]]]`)))
+	})
+}
+
+func TestValid(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Hex   string
+		Valid bool
+	}{
+		{Name: "string", Hex: "0868", Valid: true},
+		{Name: "empty_buffer", Hex: "01", Valid: true},
+		{Name: "int", Hex: "2201000000", Valid: true},
+		{Name: "double", Hex: "43000000000000f03f", Valid: true},
+		{Name: "null", Hex: "06", Valid: true},
+		{Name: "false", Hex: "0e00", Valid: true},
+		{Name: "true", Hex: "0e01", Valid: true},
+		{Name: "extended", Hex: "176401", Valid: true},
+		{Name: "empty_array", Hex: "04", Valid: true},
+		{Name: "empty_object", Hex: "05", Valid: true},
+		{Name: "nested", Hex: "6d0862220100000008611c060863", Valid: true},
+
+		{Name: "empty", Hex: "", Valid: false},
+		{Name: "truncated_tag", Hex: "80", Valid: false},
+		{Name: "overflowing_tag", Hex: "ffffffffffffffffffff01", Valid: false},
+		{Name: "huge_length", Hex: "f8ffffffffffffffff01", Valid: false},
+		{Name: "truncated_string", Hex: "1068", Valid: false},
+		{Name: "short_int", Hex: "1a010000", Valid: false},
+		{Name: "long_double", Hex: "4b000000000000f03f00", Valid: false},
+		{Name: "invalid_bool", Hex: "0e02", Valid: false},
+		{Name: "long_boolnull", Hex: "160000", Valid: false},
+		{Name: "extended_without_subtype", Hex: "07", Valid: false},
+		{Name: "extended_with_truncated_subtype", Hex: "0f80", Valid: false},
+		{Name: "odd_object", Hex: "0d06", Valid: false},
+		{Name: "child_exceeding_container", Hex: "0c0868", Valid: false},
+		{Name: "truncated_container", Hex: "1c0608", Valid: false},
+		{Name: "invalid_child", Hex: "140e02", Valid: false},
+		{Name: "trailing_bytes", Hex: "0606", Valid: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			b := h(testCase.Hex)
+			if testCase.Valid {
+				require.NoError(t, bipf.Valid(b))
+				require.NoError(t, bipf.ValidateReader(iotest.OneByteReader(bytes.NewReader(b))))
+			} else {
+				require.Error(t, bipf.Valid(b))
+				require.Error(t, bipf.ValidateReader(iotest.OneByteReader(bytes.NewReader(b))))
+			}
+		})
+	}
+
+	t.Run("huge_length_doesnt_panic", func(t *testing.T) {
+		for _, s := range []string{"f8ffffffffffffffff01", "f9ffffffffffffffff01", "ffffffffffffffffff01"} {
+			var v any
+			require.Error(t, bipf.Unmarshal(h(s), &v))
+			require.Error(t, bipf.NewDecoder(bytes.NewReader(h(s))).Decode(&v))
+		}
+	})
+
+	t.Run("max_depth", func(t *testing.T) {
+		var v any = []any{}
+		for i := 0; i < 10001; i++ {
+			v = []any{v}
+		}
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+		require.Error(t, bipf.Valid(b))
+		require.Error(t, bipf.ValidateReader(bytes.NewReader(b)))
+	})
+}
+
+func TestErrors(t *testing.T) {
+	t.Run("unmarshal_type_error", func(t *testing.T) {
+		b, err := bipf.Marshal(map[string]any{
+			"content": map[string]any{
+				"mentions": []any{
+					map[string]any{"link": "a"},
+					map[string]any{"link": "b"},
+					map[string]any{"link": int32(5)},
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		var msg struct {
+			Content struct {
+				Mentions []struct {
+					Link string `bipf:"link"`
+				} `bipf:"mentions"`
+			} `bipf:"content"`
+		}
+
+		err = bipf.Unmarshal(b, &msg)
+
+		var typeErr *bipf.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		require.Equal(t, bipf.KindInt, typeErr.Value)
+		require.Equal(t, reflect.TypeOf(""), typeErr.Type)
+		require.Equal(t, "content.mentions[2].link", typeErr.Field)
+		require.Equal(t, h("2205000000"), b[typeErr.Offset:typeErr.Offset+5])
+	})
+
+	t.Run("unmarshal_type_error_in_map", func(t *testing.T) {
+		b, err := bipf.Marshal(map[string]any{"a": []any{"b", true}})
+		require.NoError(t, err)
+
+		var m map[string][]string
+		err = bipf.Unmarshal(b, &m)
+
+		var typeErr *bipf.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		require.Equal(t, bipf.KindBoolNull, typeErr.Value)
+		require.Equal(t, "a[1]", typeErr.Field)
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		b, err := bipf.Marshal([]int32{1, 300})
+		require.NoError(t, err)
+
+		var v []int8
+		err = bipf.Unmarshal(b, &v)
+
+		var typeErr *bipf.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		require.Equal(t, bipf.KindInt, typeErr.Value)
+		require.Equal(t, reflect.TypeOf(int8(0)), typeErr.Type)
+		require.Equal(t, "[1]", typeErr.Field)
+		require.Equal(t, int64(6), typeErr.Offset)
+	})
+
+	t.Run("unmarshal_at", func(t *testing.T) {
+		b, err := bipf.Marshal(map[string]any{"a": "b"})
+		require.NoError(t, err)
+
+		var v int32
+		err = bipf.UnmarshalPath(b, &v, "a")
+
+		var typeErr *bipf.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		require.Equal(t, int64(3), typeErr.Offset)
+	})
+
+	t.Run("syntax_error", func(t *testing.T) {
+		testCases := []struct {
+			Name   string
+			Hex    string
+			Offset int64
+		}{
+			{Name: "truncated_string", Hex: "1c0610", Offset: 3},
+			{Name: "invalid_bool", Hex: "0e02", Offset: 2},
+			{Name: "trailing_bytes", Hex: "0606", Offset: 1},
+			{Name: "invalid_length", Hex: "1a010000", Offset: 1},
+		}
+
+		for _, testCase := range testCases {
+			t.Run(testCase.Name, func(t *testing.T) {
+				var v any
+				err := bipf.Unmarshal(h(testCase.Hex), &v)
+
+				var syntaxErr *bipf.SyntaxError
+				require.ErrorAs(t, err, &syntaxErr)
+				require.Equal(t, testCase.Offset, syntaxErr.Offset)
+			})
+		}
+	})
+
+	t.Run("truncated_input", func(t *testing.T) {
+		testCases := []struct {
+			Name   string
+			Hex    string
+			Offset int64
+		}{
+			{Name: "empty", Hex: "", Offset: 0},
+			{Name: "int_without_payload", Hex: "22", Offset: 1},
+			{Name: "truncated_int", Hex: "220100", Offset: 3},
+			{Name: "bool_without_payload", Hex: "0e", Offset: 1},
+			{Name: "object_without_payload", Hex: "2d", Offset: 1},
+			{Name: "key_without_value", Hex: "6d0861", Offset: 3},
+		}
+
+		for _, testCase := range testCases {
+			t.Run(testCase.Name, func(t *testing.T) {
+				var v any
+				err := bipf.Unmarshal(h(testCase.Hex), &v)
+
+				var syntaxErr *bipf.SyntaxError
+				require.ErrorAs(t, err, &syntaxErr)
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				require.Equal(t, testCase.Offset, syntaxErr.Offset)
+			})
+		}
+
+		t.Run("struct", func(t *testing.T) {
+			var v struct{ A string }
+			err := bipf.Unmarshal(h("6d0861"), &v)
+
+			var syntaxErr *bipf.SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			require.EqualError(t, err, "unexpected EOF at offset 3")
+		})
+	})
+
+	t.Run("max_depth", func(t *testing.T) {
+		var v any
+		err := bipf.Config{MaxDepth: 1}.Freeze().Unmarshal(h("140c04"), &v)
+
+		var syntaxErr *bipf.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+		require.Equal(t, int64(2), syntaxErr.Offset)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		var syntaxErr *bipf.SyntaxError
+		require.ErrorAs(t, bipf.Valid(h("1c060e02")), &syntaxErr)
+		require.Equal(t, int64(2), syntaxErr.Offset)
+	})
+
+	t.Run("unsupported_type", func(t *testing.T) {
+		for _, v := range []any{make(chan int), func() {}, complex(1, 2), map[[2]int]string{}} {
+			_, err := bipf.Marshal(v)
+
+			var typeErr *bipf.UnsupportedTypeError
+			require.ErrorAs(t, err, &typeErr)
+		}
+
+		var v chan int
+		var typeErr *bipf.UnsupportedTypeError
+		require.ErrorAs(t, bipf.Unmarshal(h("06"), &v), &typeErr)
+		require.Equal(t, reflect.TypeOf(v), typeErr.Type)
+	})
+
+	t.Run("unsupported_value", func(t *testing.T) {
+		_, err := bipf.Marshal(struct{ A int64 }{A: 1 << 40})
+
+		var valueErr *bipf.UnsupportedValueError
+		require.ErrorAs(t, err, &valueErr)
+		require.Equal(t, int64(1<<40), valueErr.Value.Int())
+	})
+
+	t.Run("float32_overflow", func(t *testing.T) {
+		b, err := bipf.Marshal(math.MaxFloat64)
+		require.NoError(t, err)
+
+		var v float32
+		var typeErr *bipf.UnmarshalTypeError
+		require.ErrorAs(t, bipf.Unmarshal(b, &v), &typeErr)
+	})
+}
+
+func TestMarshalNestedValues(t *testing.T) {
+	type inner struct {
+		Points map[string][]marshalerPoint
+		Values []any
+	}
+
+	v := map[string]any{
+		"inner": []inner{
+			{
+				Points: map[string][]marshalerPoint{
+					"a": {{X: 1, Y: 2}},
+					"b": {{X: 3, Y: 4}, {X: 5, Y: 6}},
+				},
+				Values: []any{
+					map[string]any{"c": []any{int32(1), "d"}},
+					nil,
+					[]byte{7},
+				},
+			},
+		},
+		"empty": map[string]any{},
+	}
+
+	expected := map[string]any{
+		"inner": []any{
+			map[any]any{
+				"Points": map[any]any{
+					"a": []any{[]any{int32(1), int32(2)}},
+					"b": []any{[]any{int32(3), int32(4)}, []any{int32(5), int32(6)}},
+				},
+				"Values": []any{
+					map[any]any{"c": []any{int32(1), "d"}},
+					nil,
+					[]byte{7},
+				},
+			},
+		},
+		"empty": map[any]any{},
+	}
+
+	for _, api := range []bipf.API{bipf.ConfigDefault, bipf.Config{SortMapKeys: true}.Freeze()} {
+		b, err := api.Marshal(v)
+		require.NoError(t, err)
+		require.NoError(t, bipf.Valid(b))
+
+		var decoded map[string]any
+		require.NoError(t, bipf.Unmarshal(b, &decoded))
+		require.Equal(t, expected, decoded)
+	}
+}
+
+func TestEncodingLength(t *testing.T) {
+	type omitempty struct {
+		Name    string   `bipf:",omitempty"`
+		Tags    []string `bipf:",omitempty"`
+		Pointer *int32
+		Point   marshalerPoint
+		Points  map[string]*marshalerPoint
+	}
+
+	testCases := []struct {
+		name string
+		api  bipf.API
+		v    any
+	}{
+		{name: "nil", api: bipf.ConfigDefault, v: nil},
+		{name: "string", api: bipf.ConfigDefault, v: strings.Repeat("a", 200)},
+		{name: "int64", api: bipf.ConfigDefault, v: int64(12)},
+		{name: "int64_double", api: bipf.Config{IntegerPolicy: bipf.IntegerPolicyDouble}.Freeze(), v: int64(1 << 40)},
+		{name: "int64_extended", api: bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 1, Uint64ExtendedType: 2}.Freeze(), v: int64(1 << 40)},
+		{name: "uint64_extended", api: bipf.Config{IntegerPolicy: bipf.IntegerPolicyExtended, Int64ExtendedType: 1, Uint64ExtendedType: 2}.Freeze(), v: uint64(1 << 63)},
+		{name: "empty_slice", api: bipf.ConfigDefault, v: []string{}},
+		{name: "nil_map", api: bipf.ConfigDefault, v: map[string]int(nil)},
+		{name: "omitempty", api: bipf.ConfigDefault, v: omitempty{}},
+		{
+			name: "omitempty_set",
+			api:  bipf.ConfigDefault,
+			v: omitempty{
+				Name:    "name",
+				Tags:    []string{"a", "b"},
+				Pointer: p(int32(1)),
+				Points:  map[string]*marshalerPoint{"a": {X: 1, Y: 2}, "b": nil},
+			},
+		},
+		{name: "sorted_map", api: bipf.Config{SortMapKeys: true}.Freeze(), v: map[any]any{1: "a", "b": []any{nil, true}}},
+		{name: "raw_message", api: bipf.ConfigDefault, v: []bipf.RawMessage{h("0e01"), nil}},
+		{name: "complex_struct", api: bipf.ConfigDefault, v: newComplexStruct()},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, err := testCase.api.Marshal(testCase.v)
+			require.NoError(t, err)
+
+			length, err := testCase.api.EncodingLength(testCase.v)
+			require.NoError(t, err)
+			require.Equal(t, len(b), length)
+		})
+	}
+
+	t.Run("large_payload", func(t *testing.T) {
+		v := []string{strings.Repeat("a", 1<<16), strings.Repeat("b", 1<<8)}
+
+		b, err := bipf.Marshal(v)
+		require.NoError(t, err)
+
+		length, err := bipf.EncodingLength(v)
+		require.NoError(t, err)
+		require.Equal(t, len(b), length)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := bipf.EncodingLength(int64(1 << 40))
+		var valueErr *bipf.UnsupportedValueError
+		require.ErrorAs(t, err, &valueErr)
+
+		_, err = bipf.EncodingLength(make(chan int))
+		var typeErr *bipf.UnsupportedTypeError
+		require.ErrorAs(t, err, &typeErr)
+
+		_, err = bipf.EncodingLength(marshalerRecorder(h("0e")))
+		require.Error(t, err)
+
+		for _, raw := range []bipf.RawMessage{h("0e"), h("0e010e01")} {
+			_, marshalErr := bipf.Marshal(raw)
+			require.Error(t, marshalErr)
+
+			_, err = bipf.EncodingLength(raw)
+			require.EqualError(t, err, marshalErr.Error())
+		}
+	})
+}
+
+func TestZeroCopy(t *testing.T) {
+	type testStruct struct {
+		String string
+		Buffer []byte
+		Map    map[string]string
+		Any    any
+	}
+
+	v := testStruct{
+		String: "string",
+		Buffer: []byte{0x01, 0x02},
+		Map:    map[string]string{"key": "value"},
+		Any:    "any",
+	}
+
+	b, err := bipf.Marshal(v)
+	require.NoError(t, err)
+
+	t.Run("copy_by_default", func(t *testing.T) {
+		data := append([]byte(nil), b...)
+
+		var decoded testStruct
+		require.NoError(t, bipf.Unmarshal(data, &decoded))
+		require.Equal(t, v, decoded)
+
+		for i := range data {
+			data[i] = 0
+		}
+		require.Equal(t, v, decoded)
+	})
+
+	t.Run("zero_copy", func(t *testing.T) {
+		api := bipf.Config{ZeroCopy: true}.Freeze()
+		data := append([]byte(nil), b...)
+
+		var decoded testStruct
+		require.NoError(t, api.Unmarshal(data, &decoded))
+		require.Equal(t, v, decoded)
+
+		decoded.Buffer = append(decoded.Buffer, 0x03)
+		var again testStruct
+		require.NoError(t, api.Unmarshal(data, &again))
+		require.Equal(t, v, again)
+
+		for i := range data {
+			data[i] = 'x'
+		}
+		require.Equal(t, "xxxxxx", again.String)
+		require.Equal(t, []byte("xx"), again.Buffer)
+		require.Equal(t, "xxx", again.Any)
+	})
+
+	t.Run("no_effect_on_readers", func(t *testing.T) {
+		api := bipf.Config{ZeroCopy: true}.Freeze()
+		data := append([]byte(nil), b...)
+
+		var decoded testStruct
+		require.NoError(t, api.NewDecoder(bytes.NewReader(data)).Decode(&decoded))
+
+		for i := range data {
+			data[i] = 'x'
+		}
+		require.Equal(t, v, decoded)
+	})
+
+	t.Run("truncated_input", func(t *testing.T) {
+		api := bipf.Config{ZeroCopy: true}.Freeze()
+
+		var s string
+		err := api.Unmarshal(h("30616263"), &s)
+		var syntaxErr *bipf.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+type extensionPublicKey struct {
+	key []byte
+}
+
+type extensionNoValues struct{}
+
+type extensionTwoValues struct{}
+
+type extensionContainers struct{}
+
+type extensionFieldStruct struct {
+	Name   string
+	Secret string `bipf:",omitempty"`
+	Other  string
+}
+
+type marshalerPoint struct {
+	X, Y int32
+}
+
+func (p marshalerPoint) MarshalBIPF() ([]byte, error) {
+	return bipf.Marshal([]int32{p.X, p.Y})
+}
+
+func (p *marshalerPoint) UnmarshalBIPF(b []byte) error {
+	var v []int32
+	if err := bipf.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v) != 2 {
+		return errors.New("expected two coordinates")
+	}
+	p.X = v[0]
+	p.Y = v[1]
+	return nil
+}
+
+// marshalerRecorder stores the bytes passed to UnmarshalBIPF and returns them
+// from MarshalBIPF.
+type marshalerRecorder []byte
+
+func (r marshalerRecorder) MarshalBIPF() ([]byte, error) {
+	return r, nil
+}
+
+func (r *marshalerRecorder) UnmarshalBIPF(b []byte) error {
+	*r = append((*r)[:0], b...)
+	return nil
+}
+
+type extendedTimestamp struct {
+	Millis int64
+}
+
+type extendedFlag byte
+
+// testExtension encodes time.Duration as an OBJECT with a single string
+// value, lower-cases the keys of struct fields and ignores fields named Other.
+type testExtension struct {
+	bipf.DummyExtension
+}
+
+func (e *testExtension) UpdateStructDescriptor(structDescriptor *bipf.StructDescriptor) {
+	for _, binding := range structDescriptor.Fields {
+		if binding.Field.Name() == "Other" {
+			binding.ToNames = nil
+			binding.FromNames = nil
+			continue
+		}
+		binding.ToNames = []string{strings.ToLower(binding.Field.Name())}
+		binding.FromNames = []string{strings.ToLower(binding.Field.Name())}
+	}
+}
+
+func (e *testExtension) CreateEncoder(typ reflect2.Type) bipf.ValEncoder {
+	if typ != reflect2.TypeOf(time.Duration(0)) {
+		return nil
+	}
+	return &durationCodec{}
+}
+
+func (e *testExtension) CreateDecoder(typ reflect2.Type) bipf.ValDecoder {
+	if typ != reflect2.TypeOf(time.Duration(0)) {
+		return nil
+	}
+	return &durationCodec{}
+}
+
+type durationCodec struct {
+}
+
+func (c *durationCodec) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return *(*time.Duration)(ptr) == 0, nil
+}
+
+func (c *durationCodec) Encode(ptr unsafe.Pointer, w *bipf.Writer) error {
+	return w.WriteObject(func(w *bipf.Writer) error {
+		if err := w.WriteString("value"); err != nil {
+			return err
+		}
+		return w.WriteString((*time.Duration)(ptr).String())
+	})
+}
+
+func (c *durationCodec) Decode(ptr unsafe.Pointer, r *bipf.Reader) error {
+	if _, err := r.Enter(); err != nil {
+		return err
+	}
+	if err := r.Skip(); err != nil {
+		return err
+	}
+	s, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*(*time.Duration)(ptr) = d
+	return r.Exit()
+}
+
+func BenchmarkSimpleStruct(b *testing.B) {
+	v := newSimpleStruct()
+	p := newSimpleProtoStruct()
+
+	jsonBytes, err := json.Marshal(v)
+	require.NoError(b, err)
+
+	bipfBytes, err := bipf.Marshal(v)
+	require.NoError(b, err)
+
+	protoBytes, err := proto.Marshal(p)
+	require.NoError(b, err)
+
+	b.Log("jsonBytes", len(jsonBytes))
+	b.Log("bipfBytes", len(bipfBytes))
+	b.Log("protoBytes", len(protoBytes))
+
+	b.Run("proto_marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := proto.Marshal(p)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("proto_unmarshal", func(b *testing.B) {
+		var target internal.SimpleProtobuf
+		for i := 0; i < b.N; i++ {
+			err := proto.Unmarshal(protoBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json_marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := json.Marshal(v)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json_unmarshal", func(b *testing.B) {
+		var target simpleStruct
+		for i := 0; i < b.N; i++ {
+			err := json.Unmarshal(jsonBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bipf_marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := bipf.Marshal(v)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bipf_unmarshal", func(b *testing.B) {
+		var target simpleStruct
+		for i := 0; i < b.N; i++ {
+			err := bipf.Unmarshal(bipfBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkComplexStruct(b *testing.B) {
+	v := newComplexStruct()
+	p := newComplexProtoStruct()
+
+	jsonBytes, err := json.Marshal(v)
+	require.NoError(b, err)
+
+	bipfBytes, err := bipf.Marshal(v)
+	require.NoError(b, err)
+
+	protoBytes, err := proto.Marshal(p)
+	require.NoError(b, err)
+
+	b.Log("jsonBytes", len(jsonBytes))
+	b.Log("bipfBytes", len(bipfBytes))
+	b.Log("protoBytes", len(protoBytes))
+
+	b.Run("proto_marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := proto.Marshal(p)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("proto_unmarshal", func(b *testing.B) {
+		var target internal.ComplexProtobuf
+		for i := 0; i < b.N; i++ {
+			err := proto.Unmarshal(protoBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json_marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := json.Marshal(v)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json_unmarshal", func(b *testing.B) {
+		var target complexStruct
+		for i := 0; i < b.N; i++ {
+			err := json.Unmarshal(jsonBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bipf_marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := bipf.Marshal(v)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bipf_marshal_sort_map_keys", func(b *testing.B) {
+		api := bipf.Config{SortMapKeys: true}.Freeze()
+		for i := 0; i < b.N; i++ {
+			_, err := api.Marshal(v)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bipf_unmarshal", func(b *testing.B) {
+		var target complexStruct
+		for i := 0; i < b.N; i++ {
+			err := bipf.Unmarshal(bipfBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bipf_unmarshal_zero_copy", func(b *testing.B) {
+		api := bipf.Config{ZeroCopy: true}.Freeze()
+		var target complexStruct
+		for i := 0; i < b.N; i++ {
+			err := api.Unmarshal(bipfBytes, &target)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkNestedStruct(b *testing.B) {
+	for _, depth := range []int{4, 16, 64} {
+		v := newNestedStruct(depth)
+
+		b.Run(fmt.Sprintf("json_marshal_depth_%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := json.Marshal(v)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("bipf_marshal_depth_%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := bipf.Marshal(v)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type simpleStruct struct {
+	String  string
+	Int64   int64
+	Float64 float64
+	Slice   []string
+	Bytes   []byte
+}
+
+var (
+	vHardString   = "łó\"śð"
+	vSimpleString = "string"
+	vint32        = int32(123)
+	vint64        = int64(123)
+	vfloat32      = float32(1.123)
+	vfloat64      = float64(1.123)
+)
+
+func newSimpleStruct() simpleStruct {
+	return simpleStruct{
+		String:  vSimpleString,
+		Int64:   vint64,
+		Float64: vfloat64,
+		Slice:   []string{vSimpleString},
+		Bytes:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
+	}
+}
+
+func newSimpleProtoStruct() *internal.SimpleProtobuf {
+	return &internal.SimpleProtobuf{
+		String_: vSimpleString,
+		Int64:   vint64,
+		Float64: vfloat64,
+		Slice:   []string{vSimpleString},
+		Bytes:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
+	}
+}
+
+type complexStruct struct {
+	SimpleString    string
+	HardString      string
+	SimpleStringPtr *string
+	HardStringPtr   *string
+
+	Int32    int32
+	Int64    int64
+	Int32Ptr *int32
+	Int64Ptr *int64
+
+	Float32    float32
+	Float64    float64
+	Float32Ptr *float32
+	Float64Ptr *float64
+
+	Map    map[string]any
+	MapPtr *map[string]any
+
+	Slice    []any
+	SlicePtr *[]any
+
+	Bytes    []byte
+	BytesPtr *[]byte
+
+	Struct struct {
+		SimpleString string
+		HardString   string
+
+		Int32 int32
+		Int64 int64
+
+		Float32 float32
+		Float64 float64
+
+		Map    map[string]any
+		MapPtr *map[string]any
+
+		Slice    []any
+		SlicePtr *[]any
 
 		Bytes    []byte
 		BytesPtr *[]byte
 	}
 }
 
+// nestedStruct is used to benchmark encoding values nested many levels deep.
+type nestedStruct struct {
+	Name     string
+	Values   map[string]int32
+	Children []nestedStruct
+}
+
+func newNestedStruct(depth int) nestedStruct {
+	v := nestedStruct{Name: vSimpleString, Values: map[string]int32{"a": 1, "b": 2}}
+	for i := 0; i < depth; i++ {
+		v = nestedStruct{
+			Name:     vSimpleString,
+			Values:   map[string]int32{"a": int32(i)},
+			Children: []nestedStruct{v, {Name: vHardString}},
+		}
+	}
+	return v
+}
+
 func newComplexStruct() complexStruct {
 	m := func() map[string]any {
 		return map[string]any{
@@ -2,11 +2,6 @@ package bipf
 
 import "github.com/modern-go/concurrent"
 
-var (
-	encCache = newEncoderCache()
-	decCache = newDecoderCache()
-)
-
 type encoderCache struct {
 	encoderCache *concurrent.Map
 }
@@ -0,0 +1,184 @@
+package bipf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Valid checks that buf contains exactly one well-formed value without
+// decoding it into Go values. A value is well-formed if:
+//   - all tags are valid varints,
+//   - the payloads of INTs are 4 bytes long and the payloads of DOUBLEs are
+//     8 bytes long,
+//   - the payloads of BOOLNULLs are empty or consist of a single byte equal
+//     to 0 or 1,
+//   - the payloads of EXTENDED values start with a valid varint subtype,
+//   - the payloads of ARRAYs and OBJECTs consist of well-formed values which
+//     end exactly where the container ends and OBJECTs contain an even number
+//     of values,
+//   - containers are nested no deeper than the default max depth.
+func Valid(buf []byte) error {
+	return checkValue(buf, ConfigDefault.(*frozenConfig).maxDepth)
+}
+
+// ValidateReader checks that r contains exactly one well-formed value in the
+// same way as Valid does. The input is read until EOF but isn't kept in
+// memory.
+func ValidateReader(r io.Reader) error {
+	iter := newIterator(ConfigDefault.(*frozenConfig)).Reset(r)
+
+	if err := iter.validate(); err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return err
+		}
+		return iter.annotateError(noEOF(err))
+	}
+
+	more, err := iter.more()
+	if err != nil {
+		return err
+	}
+	if more {
+		return newSyntaxError("more than one value", iter.numRead())
+	}
+	return nil
+}
+
+// checkValue checks that buf contains exactly one well-formed value.
+func checkValue(buf []byte, maxDepth int) error {
+	end, err := checkValueAt(buf, 0, maxDepth)
+	if err != nil {
+		return err
+	}
+	if end != len(buf) {
+		return newSyntaxError("more than one value", uint64(end))
+	}
+	return nil
+}
+
+// checkValueAt checks that the value which starts at the offset start in buf
+// is well-formed and returns the offset at which it ends. See Valid for the
+// definition of a well-formed value.
+func checkValueAt(buf []byte, start int, depth int) (int, error) {
+	typ, length, pos, err := readTagAt(buf, start)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkPayloadLength(typ, uint64(length)); err != nil {
+		return 0, wrapSyntaxError(err, "invalid value", uint64(start))
+	}
+
+	end := pos + length
+
+	switch typ {
+	case valueTypeBoolNull:
+		if length == 1 && buf[pos] > 1 {
+			return 0, newSyntaxError(fmt.Sprintf("invalid BOOLNULL value %d", buf[pos]), uint64(start))
+		}
+	case valueTypeExtended:
+		if _, n := binary.Uvarint(buf[pos:end]); n <= 0 {
+			return 0, newSyntaxError("invalid subtype of EXTENDED value", uint64(start))
+		}
+	case valueTypeArray, valueTypeObject:
+		if depth <= 0 {
+			return 0, newSyntaxError("exceeded max depth", uint64(start))
+		}
+		n := 0
+		for pos < end {
+			pos, err = checkValueAt(buf[:end], pos, depth-1)
+			if err != nil {
+				return 0, err
+			}
+			n++
+		}
+		if typ == valueTypeObject && n%2 != 0 {
+			return 0, newSyntaxError("key without a value in OBJECT", uint64(start))
+		}
+	}
+
+	return end, nil
+}
+
+// checkPayloadLength checks that a payload of the given length is valid for
+// a value of the given type.
+func checkPayloadLength(typ valueType, length uint64) error {
+	switch typ {
+	case valueTypeInt:
+		if length != 4 {
+			return fmt.Errorf("invalid length %d of INT", length)
+		}
+	case valueTypeDouble:
+		if length != 8 {
+			return fmt.Errorf("invalid length %d of DOUBLE", length)
+		}
+	case valueTypeBoolNull:
+		if length > 1 {
+			return fmt.Errorf("invalid length %d of BOOLNULL", length)
+		}
+	case valueTypeExtended:
+		if length == 0 {
+			return errors.New("EXTENDED value without a subtype")
+		}
+	}
+	return nil
+}
+
+// validate consumes the next value checking that it is well-formed. See Valid
+// for the definition of a well-formed value.
+func (iter *iterator) validate() error {
+	start := iter.numRead()
+
+	typ, length, err := iter.readTag()
+	if err != nil {
+		return err
+	}
+
+	if err := checkPayloadLength(typ, length); err != nil {
+		return wrapSyntaxError(err, "invalid value", uint64(start))
+	}
+
+	payloadStart := iter.numRead()
+
+	switch typ {
+	case valueTypeBoolNull:
+		if length == 1 {
+			b, err := iter.ReadByte()
+			if err != nil {
+				return iter.annotateEOF(err)
+			}
+			if b > 1 {
+				return newSyntaxError(fmt.Sprintf("invalid BOOLNULL value %d", b), start)
+			}
+		}
+		return nil
+	case valueTypeExtended:
+		if _, err := binary.ReadUvarint(iter); err != nil || iter.numRead()-payloadStart > length {
+			return newSyntaxError("invalid subtype of EXTENDED value", start)
+		}
+		return iter.skipBytes(length - (iter.numRead() - payloadStart))
+	case valueTypeArray, valueTypeObject:
+		if err := iter.incrementDepth(); err != nil {
+			return newSyntaxError("exceeded max depth", start)
+		}
+		n := 0
+		for iter.numRead()-payloadStart < length {
+			if err := iter.validate(); err != nil {
+				return err
+			}
+			n++
+		}
+		if iter.numRead()-payloadStart > length {
+			return newSyntaxError("values stored in the container exceed its length", start)
+		}
+		if typ == valueTypeObject && n%2 != 0 {
+			return newSyntaxError("key without a value in OBJECT", start)
+		}
+		return iter.decrementDepth()
+	default:
+		return iter.skipBytes(length)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/boreq/go-bipf"
+)
+
+const (
+	formatRaw    = "raw"
+	formatHex    = "hex"
+	formatBase64 = "base64"
+)
+
+type convertFlags struct {
+	format string
+	seq    bool
+	ssb    bool
+}
+
+func (f *convertFlags) register(fs *flag.FlagSet, formatName, formatUsage string) {
+	fs.StringVar(&f.format, formatName, formatRaw, formatUsage+", one of raw, hex or base64")
+	fs.BoolVar(&f.seq, "seq", false, "process a sequence of values instead of a single value")
+	fs.BoolVar(&f.ssb, "ssb", false, "represent BUFFERs as SSB-style \"<base64>.sha256\" strings")
+}
+
+func (f *convertFlags) api() (bipf.API, error) {
+	switch f.format {
+	case formatRaw, formatHex, formatBase64:
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", f.format)
+	}
+
+	// integers which don't fit in an INT, such as timestamps in milliseconds,
+	// are encoded as DOUBLEs in the same way as other implementations do
+	config := bipf.Config{IntegerPolicy: bipf.IntegerPolicyDouble}
+	if f.ssb {
+		config.JSONBufferRepresentation = bipf.BufferRepresentationSSB
+	}
+	return config.Freeze(), nil
+}
+
+func runEncode(args []string, stdin io.Reader, stdout io.Writer) error {
+	var flags convertFlags
+	fs := newFlagSet("encode")
+	flags.register(fs, "out", "output format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	api, err := flags.api()
+	if err != nil {
+		return err
+	}
+
+	data, err := readInput(fs, stdin)
+	if err != nil {
+		return err
+	}
+
+	values, err := splitJSON(data, flags.seq)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	for _, value := range values {
+		buf := &bytes.Buffer{}
+		if err := api.FromJSON(buf, bytes.NewReader(value)); err != nil {
+			return err
+		}
+		if err := writeRecord(w, flags.format, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func runDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	var flags convertFlags
+	fs := newFlagSet("decode")
+	flags.register(fs, "in", "input format")
+	pretty := fs.Bool("pretty", false, "pretty-print the produced JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	api, err := flags.api()
+	if err != nil {
+		return err
+	}
+
+	data, err := readInput(fs, stdin)
+	if err != nil {
+		return err
+	}
+
+	records, err := readRecords(data, flags.format, flags.seq)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	for _, record := range records {
+		buf := &bytes.Buffer{}
+		if err := api.ToJSON(buf, record); err != nil {
+			return err
+		}
+		if *pretty {
+			indented := &bytes.Buffer{}
+			if err := json.Indent(indented, buf.Bytes(), "", "  "); err != nil {
+				return err
+			}
+			buf = indented
+		}
+		buf.WriteByte('\n')
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// splitJSON returns the JSON values stored in data. If seq is false then data
+// must contain exactly one value. Otherwise data may contain any number of
+// values separated by whitespace, which includes JSON Lines.
+func splitJSON(data []byte, seq bool) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var values []json.RawMessage
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if !seq && len(values) != 1 {
+		return nil, fmt.Errorf("expected one JSON value but got %d, use -seq to process a sequence of values", len(values))
+	}
+
+	return values, nil
+}
+
+// writeRecord writes a single encoded value. Raw values are written as they
+// are while hex and base64 values are written on separate lines.
+func writeRecord(w io.Writer, format string, record []byte) error {
+	var err error
+	switch format {
+	case formatHex:
+		_, err = fmt.Fprintln(w, hex.EncodeToString(record))
+	case formatBase64:
+		_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(record))
+	default:
+		_, err = w.Write(record)
+	}
+	return err
+}
+
+// readRecords returns the encoded values stored in data. Raw values are split
+// according to their tags while hex and base64 values must be stored on
+// separate lines. If seq is false then data must contain exactly one value.
+// Every value is validated.
+func readRecords(data []byte, format string, seq bool) ([][]byte, error) {
+	var records [][]byte
+	switch format {
+	case formatHex, formatBase64:
+		if !seq {
+			record, err := decodeRecord(format, strings.Join(strings.Fields(string(data)), ""))
+			if err != nil {
+				return nil, fmt.Errorf("error decoding the input: %w", err)
+			}
+			if err := bipf.Valid(record); err != nil {
+				return nil, fmt.Errorf("invalid value: %w", err)
+			}
+			return [][]byte{record}, nil
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			record, err := decodeRecord(format, line)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding line %d: %w", i+1, err)
+			}
+			if err := bipf.Valid(record); err != nil {
+				return nil, fmt.Errorf("invalid value on line %d: %w", i+1, err)
+			}
+			records = append(records, record)
+		}
+	default:
+		if !seq {
+			if err := bipf.Valid(data); err != nil {
+				return nil, fmt.Errorf("invalid value: %w", err)
+			}
+			return [][]byte{data}, nil
+		}
+		r := bipf.NewReaderBytes(data)
+		for {
+			start := r.Offset()
+			if err := r.Skip(); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, fmt.Errorf("error reading the value at offset %d: %w", start, err)
+			}
+			record := data[start:r.Offset()]
+			if err := bipf.Valid(record); err != nil {
+				return nil, fmt.Errorf("invalid value at offset %d: %w", start, err)
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func decodeRecord(format, s string) ([]byte, error) {
+	if format == formatHex {
+		return hex.DecodeString(s)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
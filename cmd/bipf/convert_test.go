@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvert(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		EncodeArgs []string
+		DecodeArgs []string
+		JSON       string
+		Encoded    string
+	}{
+		{
+			Name:    "raw",
+			JSON:    `{"b":1,"a":[null,"c"]}` + "\n",
+			Encoded: string(h("6d0862220100000008611c060863")),
+		},
+		{
+			Name:       "hex",
+			EncodeArgs: []string{"-out", "hex"},
+			DecodeArgs: []string{"-in", "hex"},
+			JSON:       `{"b":1,"a":[null,"c"]}` + "\n",
+			Encoded:    "6d0862220100000008611c060863\n",
+		},
+		{
+			Name:       "base64",
+			EncodeArgs: []string{"-out", "base64"},
+			DecodeArgs: []string{"-in", "base64"},
+			JSON:       `{"b":1,"a":[null,"c"]}` + "\n",
+			Encoded:    "bQhiIgEAAAAIYRwGCGM=\n",
+		},
+		{
+			Name:    "large_int",
+			JSON:    "1690000000000\n",
+			Encoded: string(h("4300004098bd977842")),
+		},
+		{
+			Name:       "seq_raw",
+			EncodeArgs: []string{"-seq"},
+			DecodeArgs: []string{"-seq"},
+			JSON:       "1\n\"a\"\n[]\n",
+			Encoded:    string(h("22010000000861" + "04")),
+		},
+		{
+			Name:       "seq_hex",
+			EncodeArgs: []string{"-seq", "-out", "hex"},
+			DecodeArgs: []string{"-seq", "-in", "hex"},
+			JSON:       "1\n\"a\"\n[]\n",
+			Encoded:    "2201000000\n0861\n04\n",
+		},
+		{
+			Name:       "seq_base64",
+			EncodeArgs: []string{"-seq", "-out", "base64"},
+			DecodeArgs: []string{"-seq", "-in", "base64"},
+			JSON:       "1\n\"a\"\n[]\n",
+			Encoded:    "IgEAAAA=\nCGE=\nBA==\n",
+		},
+		{
+			Name:       "ssb",
+			EncodeArgs: []string{"-ssb", "-out", "hex"},
+			DecodeArgs: []string{"-ssb", "-in", "hex"},
+			JSON:       `["AQID.sha256","AQID"]` + "\n",
+			Encoded:    "4c190102032041514944\n",
+		},
+		{
+			Name:       "pretty",
+			EncodeArgs: []string{"-out", "hex"},
+			DecodeArgs: []string{"-in", "hex", "-pretty"},
+			JSON:       "{\n  \"b\": 1,\n  \"a\": [\n    null,\n    \"c\"\n  ]\n}\n",
+			Encoded:    "6d0862220100000008611c060863\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			encoded := &bytes.Buffer{}
+			require.NoError(t, run(append([]string{"encode"}, testCase.EncodeArgs...), strings.NewReader(testCase.JSON), encoded))
+			require.Equal(t, testCase.Encoded, encoded.String())
+
+			decoded := &bytes.Buffer{}
+			require.NoError(t, run(append([]string{"decode"}, testCase.DecodeArgs...), encoded, decoded))
+			require.Equal(t, testCase.JSON, decoded.String())
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Args  []string
+		Input string
+		Error string
+	}{
+		{
+			Name:  "encode_sequence_without_seq",
+			Args:  []string{"encode"},
+			Input: "1 2",
+			Error: "expected one JSON value but got 2, use -seq to process a sequence of values",
+		},
+		{
+			Name:  "encode_unknown_format",
+			Args:  []string{"encode", "-out", "json"},
+			Input: "1",
+			Error: "unknown format 'json'",
+		},
+		{
+			Name:  "decode_raw_trailing_bytes",
+			Args:  []string{"decode"},
+			Input: string(h("0606")),
+			Error: "invalid value: more than one value at offset 1",
+		},
+		{
+			Name:  "decode_raw_invalid_value",
+			Args:  []string{"decode"},
+			Input: string(h("0e02")),
+			Error: "invalid value: invalid BOOLNULL value 2 at offset 0",
+		},
+		{
+			Name:  "decode_hex_trailing_bytes",
+			Args:  []string{"decode", "-in", "hex"},
+			Input: "06\n06\n",
+			Error: "invalid value: more than one value at offset 1",
+		},
+		{
+			Name:  "decode_seq_raw_truncated",
+			Args:  []string{"decode", "-seq"},
+			Input: string(h("061068")),
+			Error: "error reading the value at offset 1: unexpected EOF at offset 3",
+		},
+		{
+			Name:  "decode_seq_hex_invalid_hex",
+			Args:  []string{"decode", "-seq", "-in", "hex"},
+			Input: "06\n\nzz\n",
+			Error: "error decoding line 3: encoding/hex: invalid byte: U+007A 'z'",
+		},
+		{
+			Name:  "decode_seq_base64_invalid_base64",
+			Args:  []string{"decode", "-seq", "-in", "base64"},
+			Input: "Bg==\n!!!!\n",
+			Error: "error decoding line 2: illegal base64 data at input byte 0",
+		},
+		{
+			Name:  "decode_seq_hex_invalid_value",
+			Args:  []string{"decode", "-seq", "-in", "hex"},
+			Input: "06\n0e02\n",
+			Error: "invalid value on line 2: invalid BOOLNULL value 2 at offset 0",
+		},
+		{
+			Name:  "decode_hex_invalid_hex",
+			Args:  []string{"decode", "-in", "hex"},
+			Input: "0",
+			Error: "error decoding the input: encoding/hex: odd length hex string",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			err := run(testCase.Args, strings.NewReader(testCase.Input), &bytes.Buffer{})
+			require.EqualError(t, err, testCase.Error)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/boreq/go-bipf"
+)
+
+// maxInspectedBufferLength limits how many bytes of a BUFFER or of the data
+// of an EXTENDED value are printed.
+const maxInspectedBufferLength = 32
+
+func runInspect(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("inspect")
+	format := fs.String("in", formatRaw, "input format, one of raw, hex or base64")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := readInput(fs, stdin)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case formatRaw:
+	case formatHex, formatBase64:
+		data, err = decodeRecord(*format, strings.Join(strings.Fields(string(data)), ""))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+
+	return inspect(stdout, data)
+}
+
+// inspect prints a line for each value stored in data. The values are
+// indented according to how deeply they are nested. If data is malformed
+// then a hex dump marking the offset at which parsing stopped is printed and
+// an error is returned.
+func inspect(w io.Writer, data []byte) error {
+	fmt.Fprintf(w, "%-8s  %-10s  %-24s  %-6s  %s\n", "OFFSET", "TAG", "TYPE", "LENGTH", "VALUE")
+
+	i := &inspector{
+		w:    w,
+		data: data,
+		r:    bipf.NewReaderBytes(data),
+	}
+
+	if err := i.inspectValues(); err != nil {
+		fmt.Fprintf(w, "\nparsing stopped at offset %d: %s\n\n", i.offset, err)
+		dumpAround(w, data, i.offset)
+		return errors.New("malformed input")
+	}
+
+	return nil
+}
+
+type inspector struct {
+	w    io.Writer
+	data []byte
+	r    *bipf.Reader
+
+	// offset is the offset of the value which is being inspected.
+	offset int
+}
+
+// inspectValues inspects the values in the current container or, at the top
+// level, in the entire input.
+func (i *inspector) inspectValues() error {
+	for {
+		i.offset = i.r.Offset()
+
+		kind, length, err := i.r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err := i.inspectValue(kind, length); err != nil {
+			return err
+		}
+	}
+}
+
+func (i *inspector) inspectValue(kind bipf.Kind, length int) error {
+	start := i.r.Offset()
+	depth := i.r.Depth()
+
+	var value string
+	switch kind {
+	case bipf.KindArray, bipf.KindObject:
+		if _, err := i.r.Enter(); err != nil {
+			return err
+		}
+		i.printLine(start, depth, kind, length, "")
+		if err := i.inspectValues(); err != nil {
+			return err
+		}
+		return i.r.Exit()
+	case bipf.KindString:
+		s, err := i.r.ReadString()
+		if err != nil {
+			return err
+		}
+		value = strconv.Quote(s)
+	case bipf.KindBuffer:
+		b, err := i.r.ReadBuffer()
+		if err != nil {
+			return err
+		}
+		value = formatBytes(b)
+	case bipf.KindInt:
+		v, err := i.r.ReadInt32()
+		if err != nil {
+			return err
+		}
+		value = strconv.FormatInt(int64(v), 10)
+	case bipf.KindDouble:
+		v, err := i.r.ReadFloat64()
+		if err != nil {
+			return err
+		}
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case bipf.KindBoolNull:
+		if length == 0 {
+			if err := i.r.ReadNil(); err != nil {
+				return err
+			}
+			value = "null"
+		} else {
+			v, err := i.r.ReadBool()
+			if err != nil {
+				return err
+			}
+			value = strconv.FormatBool(v)
+		}
+	case bipf.KindExtended:
+		subtype, data, err := i.r.ReadExtended()
+		if err != nil {
+			return err
+		}
+		value = fmt.Sprintf("subtype=%d data=%s", subtype, formatBytes(data))
+	default:
+		return fmt.Errorf("unknown type %s", kind)
+	}
+
+	i.printLine(start, depth, kind, length, value)
+	return nil
+}
+
+func (i *inspector) printLine(start, depth int, kind bipf.Kind, length int, value string) {
+	// The tag is followed by the payload in the case of scalars and by the
+	// children in the case of containers, so the end of the tag is the
+	// current offset minus the length of the payload for scalars and the
+	// current offset for containers.
+	tagEnd := i.r.Offset()
+	if kind != bipf.KindArray && kind != bipf.KindObject {
+		tagEnd -= length
+	}
+
+	typ := strings.Repeat("  ", depth) + kind.String()
+	fmt.Fprintf(i.w, "%-8d  %-10s  %-24s  %-6d  %s\n", start, hex.EncodeToString(i.data[start:tagEnd]), typ, length, value)
+}
+
+func formatBytes(b []byte) string {
+	if len(b) > maxInspectedBufferLength {
+		return hex.EncodeToString(b[:maxInspectedBufferLength]) + "..."
+	}
+	return hex.EncodeToString(b)
+}
+
+// dumpAround prints a hex dump of the rows of data surrounding offset with
+// the byte at offset marked.
+func dumpAround(w io.Writer, data []byte, offset int) {
+	const (
+		rowLength = 16
+		rows      = 2
+	)
+
+	row := offset / rowLength
+	first := row - rows
+	if first < 0 {
+		first = 0
+	}
+	last := row + rows
+
+	for r := first; r <= last && r*rowLength <= len(data); r++ {
+		start := r * rowLength
+		end := start + rowLength
+		if end > len(data) {
+			end = len(data)
+		}
+
+		var line strings.Builder
+		for j := start; j < end; j++ {
+			fmt.Fprintf(&line, " %02x", data[j])
+		}
+		fmt.Fprintf(w, "%08x %s\n", start, line.String())
+
+		if r == row {
+			fmt.Fprintf(w, "%8s %s ^^ offset %d\n", "", strings.Repeat("   ", offset-start), offset)
+		}
+	}
+}
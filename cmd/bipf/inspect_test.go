@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	const expected = `OFFSET    TAG         TYPE                      LENGTH  VALUE
+0         6d          OBJECT                    13      ` + `
+1         08            STRING                  1       "b"
+3         22            INT                     4       1
+8         08            STRING                  1       "a"
+10        1c            ARRAY                   3       ` + `
+11        06              BOOLNULL              0       null
+12        08              STRING                1       "c"
+`
+
+	t.Run("raw", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		require.NoError(t, run([]string{"inspect"}, bytes.NewReader(h("6d0862220100000008611c060863")), stdout))
+		require.Equal(t, expected, stdout.String())
+	})
+
+	t.Run("hex", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		require.NoError(t, run([]string{"inspect", "-in", "hex"}, strings.NewReader("6d08622201000000\n08611c060863\n"), stdout))
+		require.Equal(t, expected, stdout.String())
+	})
+
+	t.Run("base64", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		require.NoError(t, run([]string{"inspect", "-in", "base64"}, strings.NewReader("bQhiIgEAAAAIYRwGCGM="), stdout))
+		require.Equal(t, expected, stdout.String())
+	})
+
+	t.Run("scalars", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		require.NoError(t, run([]string{"inspect"}, bytes.NewReader(h("84010e01170102099a43000000000000f83f")), stdout))
+		require.Equal(t, `OFFSET    TAG         TYPE                      LENGTH  VALUE
+0         8401        ARRAY                     16      `+`
+2         0e            BOOLNULL                1       true
+4         17            EXTENDED                2       subtype=1 data=02
+7         09            BUFFER                  1       9a
+9         43            DOUBLE                  8       1.5
+`, stdout.String())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// an OBJECT which declares 33 bytes of children but contains only 32
+		data := h("8d02" + strings.Repeat("0868", 16) + "08")
+
+		stdout := &bytes.Buffer{}
+		err := run([]string{"inspect"}, bytes.NewReader(data), stdout)
+		require.EqualError(t, err, "malformed input")
+
+		out := stdout.String()
+		require.Contains(t, out, "32        08            STRING                  1       \"h\"\n")
+		require.Contains(t, out, "\nparsing stopped at offset 34: ")
+		require.True(t, strings.HasSuffix(out, `
+00000000  8d 02 08 68 08 68 08 68 08 68 08 68 08 68 08 68
+00000010  08 68 08 68 08 68 08 68 08 68 08 68 08 68 08 68
+00000020  08 68 08
+                ^^ offset 34
+`), out)
+	})
+
+	t.Run("unknown_format", func(t *testing.T) {
+		require.Error(t, run([]string{"inspect", "-in", "json"}, strings.NewReader(""), &bytes.Buffer{}))
+	})
+}
+
+func h(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
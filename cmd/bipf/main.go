@@ -0,0 +1,111 @@
+// Command bipf is a tool for working with BIPF-encoded data.
+//
+// Usage:
+//
+//	bipf inspect [-in raw|hex|base64] [file]
+//	bipf encode [-out raw|hex|base64] [-seq] [-ssb] [file]
+//	bipf decode [-in raw|hex|base64] [-seq] [-ssb] [-pretty] [file]
+//
+// The inspect subcommand prints a tree of the values stored in the input
+// together with their offsets, tags, types and lengths. If the input is
+// malformed then the values which were parsed successfully are printed
+// followed by a hex dump marking the offset at which parsing stopped. The
+// input is read from the file or, if no file is given, from standard input.
+//
+// The encode subcommand converts JSON to BIPF and the decode subcommand
+// converts BIPF to JSON. By default a single value is converted. With -seq
+// encode converts a sequence of JSON values, such as JSON Lines, and decode
+// converts a sequence of BIPF values, producing JSON Lines. Raw BIPF values in
+// a sequence are concatenated while hex and base64 values are placed on
+// separate lines. With -ssb BUFFERs are represented in JSON as SSB-style
+// "<base64>.sha256" strings instead of plain base64 strings.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "bipf: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = []command{
+	{
+		name:        "inspect",
+		description: "print an annotated tree of BIPF values",
+		run:         runInspect,
+	},
+	{
+		name:        "encode",
+		description: "convert JSON to BIPF",
+		run:         runEncode,
+	},
+	{
+		name:        "decode",
+		description: "convert BIPF to JSON",
+		run:         runDecode,
+	},
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		usage()
+		return flag.ErrHelp
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], stdin, stdout)
+		}
+	}
+
+	usage()
+	return fmt.Errorf("unknown command '%s'", args[0])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: bipf <command> [flags] [file]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bipf %s [flags] [file]\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// readInput reads the entire input from the file named by the only
+// positional argument or, if there are no positional arguments, from stdin.
+func readInput(fs *flag.FlagSet, stdin io.Reader) ([]byte, error) {
+	switch fs.NArg() {
+	case 0:
+		return io.ReadAll(stdin)
+	case 1:
+		return os.ReadFile(fs.Arg(0))
+	default:
+		return nil, fmt.Errorf("expected at most one file but got %d", fs.NArg())
+	}
+}
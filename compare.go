@@ -0,0 +1,218 @@
+package bipf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Compare compares two BIPF-encoded values, the first one starting at the
+// offset startA in a and the second one starting at the offset startB in b,
+// without decoding them. The result will be 0 if the values are equal, -1 if
+// the first value sorts before the second one and +1 otherwise.
+//
+// A start offset equal to -1, as returned by SeekKey or Seeker.Seek when a
+// value is missing, sorts before all values. A null sorts before all other
+// values. Values of different types are ordered by their BIPF type in the
+// following order: STRING, BUFFER, INT and DOUBLE, ARRAY, OBJECT, BOOLNULL,
+// EXTENDED. Values of the same type are compared as follows:
+//
+// STRING, BUFFER and EXTENDED values are compared byte by byte, if one of them
+// is a prefix of the other then the shorter one sorts first.
+//
+// INT and DOUBLE values are compared numerically with each other, therefore
+// an INT and a DOUBLE representing the same number are equal. NaN is equal to
+// itself and sorts before all other numbers.
+//
+// BOOLNULL values are compared so that false sorts before true.
+//
+// ARRAY values are compared element by element, OBJECT values are compared key
+// by key and value by value in the order in which they were encoded. If one of
+// them is a prefix of the other then the shorter one sorts first. An error is
+// returned if containers are nested deeper than the default max depth.
+func Compare(a []byte, startA int, b []byte, startB int) (int, error) {
+	return compareAt(a, startA, b, startB, ConfigDefault.(*frozenConfig).maxDepth)
+}
+
+// compareAt compares two values in the same way as Compare does. Containers
+// can be nested at most depth levels deep.
+func compareAt(a []byte, startA int, b []byte, startB int, depth int) (int, error) {
+	if startA == -1 || startB == -1 {
+		return compareInts(startA, startB), nil
+	}
+
+	typA, lengthA, posA, err := readTagAt(a, startA)
+	if err != nil {
+		return 0, err
+	}
+
+	typB, lengthB, posB, err := readTagAt(b, startB)
+	if err != nil {
+		return 0, err
+	}
+
+	nilA := typA == valueTypeBoolNull && lengthA == 0
+	nilB := typB == valueTypeBoolNull && lengthB == 0
+	switch {
+	case nilA && nilB:
+		return 0, nil
+	case nilA:
+		return -1, nil
+	case nilB:
+		return 1, nil
+	}
+
+	if compareRank(typA) != compareRank(typB) {
+		return compareInts(compareRank(typA), compareRank(typB)), nil
+	}
+
+	payloadA := a[posA : posA+lengthA]
+	payloadB := b[posB : posB+lengthB]
+
+	switch typA {
+	case valueTypeInt, valueTypeDouble:
+		floatA, err := readNumberPayload(typA, payloadA)
+		if err != nil {
+			return 0, err
+		}
+		floatB, err := readNumberPayload(typB, payloadB)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloats(floatA, floatB), nil
+	case valueTypeArray, valueTypeObject:
+		if depth <= 0 {
+			return 0, newSyntaxError("exceeded max depth", uint64(startA))
+		}
+		return compareChildren(a[:posA+lengthA], posA, b[:posB+lengthB], posB, depth-1)
+	default:
+		return bytes.Compare(payloadA, payloadB), nil
+	}
+}
+
+// compareChildren compares the children of two containers whose payloads
+// start at the offsets posA and posB and end where a and b end.
+func compareChildren(a []byte, posA int, b []byte, posB int, depth int) (int, error) {
+	for posA < len(a) && posB < len(b) {
+		result, err := compareAt(a, posA, b, posB, depth)
+		if err != nil {
+			return 0, err
+		}
+
+		if result != 0 {
+			return result, nil
+		}
+
+		posA, err = skipAt(a, posA)
+		if err != nil {
+			return 0, err
+		}
+
+		posB, err = skipAt(b, posB)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return compareInts(len(a)-posA, len(b)-posB), nil
+}
+
+// compareRank returns the position of the type in the ordering used by Compare.
+// INT and DOUBLE values are compared with each other so they share a position.
+func compareRank(typ valueType) int {
+	if typ == valueTypeDouble {
+		return int(valueTypeInt)
+	}
+	return int(typ)
+}
+
+// readNumberPayload reads the payload of an INT or a DOUBLE. Every int32 can be
+// represented exactly as a float64.
+func readNumberPayload(typ valueType, payload []byte) (float64, error) {
+	if typ == valueTypeInt {
+		if len(payload) != 4 {
+			return 0, errors.New("invalid length")
+		}
+		return float64(int32(binary.LittleEndian.Uint32(payload))), nil
+	}
+	if len(payload) != 8 {
+		return 0, errors.New("invalid length")
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(payload)), nil
+}
+
+// compareFloats compares two numbers so that NaN is equal to itself and sorts
+// before all other numbers, which makes the ordering total.
+func compareFloats(a, b float64) int {
+	nanA := math.IsNaN(a)
+	nanB := math.IsNaN(b)
+	switch {
+	case nanA && nanB:
+		return 0
+	case nanA:
+		return -1
+	case nanB:
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// SortByPath sorts BIPF-encoded records by the values found at the given path
+// in each of them using the ordering defined by Compare. The path is
+// interpreted in the same way as in the case of CompilePath. Records which
+// don't contain a value at the given path sort before all other records. The
+// sort is stable.
+func SortByPath(records [][]byte, path ...any) error {
+	seeker, err := CompilePath(path...)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]sortableRecord, len(records))
+	for i := range records {
+		offset, err := seeker.Seek(records[i], 0)
+		if err != nil {
+			return wrapf(err, "error seeking in record %d", i)
+		}
+		sorted[i] = sortableRecord{record: records[i], offset: offset}
+	}
+
+	var compareErr error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		result, err := Compare(sorted[i].record, sorted[i].offset, sorted[j].record, sorted[j].offset)
+		if err != nil && compareErr == nil {
+			compareErr = err
+		}
+		return result < 0
+	})
+	if compareErr != nil {
+		return compareErr
+	}
+
+	for i := range sorted {
+		records[i] = sorted[i].record
+	}
+	return nil
+}
+
+type sortableRecord struct {
+	record []byte
+	offset int
+}
@@ -0,0 +1,435 @@
+package bipf
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/modern-go/concurrent"
+)
+
+const (
+	defaultTagKey   = "bipf"
+	defaultMaxDepth = 10000
+)
+
+// IntegerPolicy determines how integers which don't fit in a BIPF INT, which
+// is a signed 32-bit integer, are encoded.
+type IntegerPolicy int
+
+const (
+	// IntegerPolicyError causes an error to be returned when encoding an
+	// integer which doesn't fit in an INT.
+	IntegerPolicyError IntegerPolicy = iota
+
+	// IntegerPolicyDouble causes integers which don't fit in an INT to be
+	// encoded as DOUBLE values. An error is returned if an integer can't be
+	// represented exactly as a DOUBLE, which can happen if its absolute
+	// value is larger than 2^53.
+	IntegerPolicyDouble
+
+	// IntegerPolicyExtended causes integers which don't fit in an INT to be
+	// encoded as EXTENDED values of subtype Config.Int64ExtendedType, in the
+	// case of signed integers, or Config.Uint64ExtendedType, in the case of
+	// unsigned integers.
+	IntegerPolicyExtended
+)
+
+// ObjectRepresentation determines the type of the values produced when an
+// OBJECT is decoded into an interface value.
+type ObjectRepresentation int
+
+const (
+	// ObjectRepresentationAnyMap causes OBJECTs to be decoded as
+	// map[any]any.
+	ObjectRepresentationAnyMap ObjectRepresentation = iota
+
+	// ObjectRepresentationStringMap causes OBJECTs to be decoded as
+	// map[string]any. An error is returned if a key isn't a STRING.
+	ObjectRepresentationStringMap
+
+	// ObjectRepresentationStringMapStringifyKeys causes OBJECTs to be
+	// decoded as map[string]any. Keys which aren't STRINGs are decoded and
+	// converted to strings using fmt.Sprint.
+	ObjectRepresentationStringMapStringifyKeys
+)
+
+// IntRepresentation determines the type of the values produced when an INT is
+// decoded into an interface value.
+type IntRepresentation int
+
+const (
+	// IntRepresentationInt32 causes INTs to be decoded as int32.
+	IntRepresentationInt32 IntRepresentation = iota
+
+	// IntRepresentationInt causes INTs to be decoded as int.
+	IntRepresentationInt
+
+	// IntRepresentationInt64 causes INTs to be decoded as int64.
+	IntRepresentationInt64
+)
+
+// BufferRepresentation determines how BUFFERs are represented in JSON by
+// ToJSON and FromJSON.
+type BufferRepresentation int
+
+const (
+	// BufferRepresentationBase64 represents BUFFERs as JSON strings holding
+	// their contents encoded using standard base64 encoding. FromJSON never
+	// produces BUFFERs when this representation is used as such strings
+	// can't be distinguished from other strings.
+	BufferRepresentationBase64 BufferRepresentation = iota
+
+	// BufferRepresentationSSB represents BUFFERs as JSON strings holding
+	// their contents encoded using standard base64 encoding followed by the
+	// ".sha256" suffix, as used by Secure Scuttlebutt. FromJSON converts all
+	// strings which have this form to BUFFERs.
+	BufferRepresentationSSB
+)
+
+// Config customizes the behaviour of encoding and decoding. A Config has to be
+// frozen using Freeze to produce an API which can then be used to encode and
+// decode values. The zero value of Config is valid and results in the default
+// behaviour.
+type Config struct {
+	// TagKey is the key under which the encoding of struct fields is
+	// customized in the struct field tags. Defaults to "bipf".
+	TagKey string
+
+	// MaxDepth limits how deeply decoded containers can be nested. Defaults
+	// to 10000.
+	MaxDepth int
+
+	// DisallowUnknownFields causes an error to be returned when an OBJECT
+	// decoded into a struct contains a key which doesn't match any of the
+	// struct's fields. By default such keys are ignored.
+	DisallowUnknownFields bool
+
+	// CaseSensitive causes the keys of an OBJECT decoded into a struct to
+	// only match a field if they are exactly equal to the name of the field
+	// or the name specified in its tag. By default a case-insensitive match
+	// is also accepted.
+	CaseSensitive bool
+
+	// DisallowDuplicateFields causes an error to be returned when multiple
+	// keys of an OBJECT decoded into a struct match the same field, either
+	// because the keys are equal or because they only differ in case, such as
+	// "id" and "ID", and CaseSensitive isn't set. By default the value stored
+	// under the key which was encoded last is used in that case.
+	DisallowDuplicateFields bool
+
+	// SortMapKeys causes the entries of maps to be encoded in the order of
+	// their encoded keys as defined by Compare. This makes the encoding of
+	// maps deterministic. By default maps are encoded in their iteration
+	// order, which is random.
+	SortMapKeys bool
+
+	// LegacyMarshalers restores the behaviour of the previous versions of
+	// this package for types implementing Marshaler and Unmarshaler:
+	// UnmarshalBIPF receives only the payload of a value, without its tag,
+	// and the output of MarshalBIPF isn't checked. By default UnmarshalBIPF
+	// receives the entire encoded value and MarshalBIPF must return exactly
+	// one well-formed value.
+	LegacyMarshalers bool
+
+	// IntegerPolicy determines how integers which don't fit in a BIPF INT
+	// are encoded. By default an error is returned.
+	IntegerPolicy IntegerPolicy
+
+	// Int64ExtendedType and Uint64ExtendedType are the subtypes of the
+	// EXTENDED values holding signed and unsigned integers when
+	// IntegerPolicy is IntegerPolicyExtended. The payload of such a value is
+	// the integer encoded using 8 bytes in little-endian byte order. The
+	// BIPF specification doesn't define these subtypes so they have to be
+	// agreed upon with the other implementations which read the values.
+	// The subtypes must be different. With IntegerPolicyExtended EXTENDED
+	// values of these subtypes are also decoded as integers, even if a type
+	// was registered for the subtype using API.RegisterExtendedType.
+	Int64ExtendedType  uint64
+	Uint64ExtendedType uint64
+
+	// ObjectRepresentation determines the type of the values produced when
+	// an OBJECT is decoded into an interface value. Defaults to
+	// map[any]any.
+	ObjectRepresentation ObjectRepresentation
+
+	// IntRepresentation determines the type of the values produced when an
+	// INT is decoded into an interface value. Defaults to int32.
+	IntRepresentation IntRepresentation
+
+	// UseNumber causes INTs and DOUBLEs decoded into an interface value to
+	// be stored as a Number instead of a number type determined by
+	// IntRepresentation or a float64.
+	UseNumber bool
+
+	// JSONBufferRepresentation determines how BUFFERs are represented in
+	// JSON by ToJSON and FromJSON. Defaults to base64-encoded strings.
+	JSONBufferRepresentation BufferRepresentation
+
+	// ZeroCopy causes strings and byte slices decoded from STRINGs and
+	// BUFFERs to refer to the memory of the input instead of holding
+	// copies of it when decoding from a byte slice, for example using
+	// Unmarshal. This avoids allocations but the input must not be
+	// modified or freed as long as the decoded values are in use. It has
+	// no effect when decoding from an io.Reader.
+	ZeroCopy bool
+}
+
+// API encodes and decodes values according to a frozen Config. Each API has
+// its own caches of encoders and decoders, therefore APIs produced by
+// separate calls to Freeze don't affect each other. It is safe to use an API
+// concurrently.
+type API interface {
+	Marshal(v any) ([]byte, error)
+	EncodingLength(v any) (int, error)
+	Unmarshal(data []byte, v any) error
+	UnmarshalAt(data []byte, offset int, v any) error
+	UnmarshalPath(data []byte, v any, path ...any) error
+	UnmarshalSeek(data []byte, seeker *Seeker, v any) error
+	NewEncoder(w io.Writer) *Encoder
+	NewDecoder(r io.Reader) *Decoder
+	NewReader(r io.Reader) *Reader
+	NewReaderBytes(buf []byte) *Reader
+	ToJSON(dst io.Writer, src []byte) error
+	FromJSON(dst io.Writer, src io.Reader) error
+
+	// RegisterExtension registers an extension which is only used by this
+	// API and the APIs derived from it, for example by
+	// Decoder.DisallowUnknownFields. It has to be called before the API is
+	// used.
+	RegisterExtension(extension Extension)
+
+	// RegisterExtendedType registers a Go type which is encoded as an
+	// EXTENDED value, see NewExtendedType. Values of the registered type
+	// encode as EXTENDED values and EXTENDED values of the registered
+	// subtype decode into the registered type, also when they are decoded
+	// into an interface value. The type is only registered in this API and
+	// the APIs derived from it, therefore different APIs can map the same
+	// subtype to different types. It panics if the type or the subtype was
+	// already registered in this API and it has to be called before the
+	// API is used.
+	RegisterExtendedType(t *ExtendedType)
+}
+
+// ConfigDefault is the API used by the package-level functions such as Marshal
+// and Unmarshal.
+var ConfigDefault = Config{}.Freeze()
+
+type frozenConfig struct {
+	configBeforeFrozen       Config
+	tagKey                   string
+	maxDepth                 int
+	disallowUnknownFields    bool
+	caseSensitive            bool
+	disallowDuplicateFields  bool
+	sortMapKeys              bool
+	legacyMarshalers         bool
+	integerPolicy            IntegerPolicy
+	int64ExtendedType        uint64
+	uint64ExtendedType       uint64
+	objectRepresentation     ObjectRepresentation
+	intRepresentation        IntRepresentation
+	useNumber                bool
+	jsonBufferRepresentation BufferRepresentation
+	zeroCopy                 bool
+	extensions               *extensionRegistry
+	extendedTypes            *extendedTypeRegistry
+	derivedConfigs           *concurrent.Map
+	encoderCache             *encoderCache
+	decoderCache             *decoderCache
+	streamPool               *syncStreamPool
+	iteratorPool             *syncIteratorPool
+}
+
+// Freeze produces an API from the config. Each call to Freeze creates new
+// caches of encoders and decoders so it should be called once and the
+// returned API should be reused.
+func (cfg Config) Freeze() API {
+	api := &frozenConfig{
+		configBeforeFrozen:       cfg,
+		tagKey:                   cfg.TagKey,
+		maxDepth:                 cfg.MaxDepth,
+		disallowUnknownFields:    cfg.DisallowUnknownFields,
+		caseSensitive:            cfg.CaseSensitive,
+		disallowDuplicateFields:  cfg.DisallowDuplicateFields,
+		sortMapKeys:              cfg.SortMapKeys,
+		legacyMarshalers:         cfg.LegacyMarshalers,
+		integerPolicy:            cfg.IntegerPolicy,
+		int64ExtendedType:        cfg.Int64ExtendedType,
+		uint64ExtendedType:       cfg.Uint64ExtendedType,
+		objectRepresentation:     cfg.ObjectRepresentation,
+		intRepresentation:        cfg.IntRepresentation,
+		useNumber:                cfg.UseNumber,
+		jsonBufferRepresentation: cfg.JSONBufferRepresentation,
+		zeroCopy:                 cfg.ZeroCopy,
+		extensions:               &extensionRegistry{},
+		extendedTypes:            &extendedTypeRegistry{},
+		derivedConfigs:           concurrent.NewMap(),
+		encoderCache:             newEncoderCache(),
+		decoderCache:             newDecoderCache(),
+	}
+	if api.tagKey == "" {
+		api.tagKey = defaultTagKey
+	}
+	if api.maxDepth == 0 {
+		api.maxDepth = defaultMaxDepth
+	}
+	api.streamPool = newSyncStreamPool(api)
+	api.iteratorPool = newSyncIteratorPool(api)
+	return api
+}
+
+// derive returns an API which uses the given config and shares the extensions
+// and the extended types registered in this API. It is used to derive APIs with modified options, for
+// example by Decoder.DisallowUnknownFields. Derived APIs are cached so that
+// their encoders and decoders are reused.
+func (cfg *frozenConfig) derive(config Config) *frozenConfig {
+	if config == cfg.configBeforeFrozen {
+		return cfg
+	}
+	obj, found := cfg.derivedConfigs.Load(config)
+	if found {
+		return obj.(*frozenConfig)
+	}
+	api := config.Freeze().(*frozenConfig)
+	api.extensions = cfg.extensions
+	api.extendedTypes = cfg.extendedTypes
+	cfg.derivedConfigs.Store(config, api)
+	return api
+}
+
+func (cfg *frozenConfig) RegisterExtension(extension Extension) {
+	cfg.extensions.register(extension)
+}
+
+func (cfg *frozenConfig) RegisterExtendedType(t *ExtendedType) {
+	cfg.extendedTypes.register(t)
+}
+
+// extendedInteger reports whether EXTENDED values of the given subtype hold
+// integers and whether those integers are signed. See IntegerPolicyExtended.
+func (cfg *frozenConfig) extendedInteger(subtype uint64) (isInteger bool, signed bool) {
+	if cfg.integerPolicy != IntegerPolicyExtended {
+		return false, false
+	}
+	switch subtype {
+	case cfg.int64ExtendedType:
+		return true, true
+	case cfg.uint64ExtendedType:
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+func (cfg *frozenConfig) getExtensions() []Extension {
+	return cfg.extensions.get()
+}
+
+// extensionRegistry holds the extensions registered in an API. It is shared
+// by the API and the APIs derived from it.
+type extensionRegistry struct {
+	lock       sync.RWMutex
+	extensions []Extension
+}
+
+func (r *extensionRegistry) register(extension Extension) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.extensions = append(r.extensions, extension)
+}
+
+// get returns the registered extensions. Extensions are only ever appended so
+// the returned slice isn't modified by later registrations.
+func (r *extensionRegistry) get() []Extension {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.extensions
+}
+
+func (cfg *frozenConfig) Marshal(v any) ([]byte, error) {
+	stream := cfg.streamPool.BorrowStream(nil)
+	defer cfg.streamPool.ReturnStream(stream)
+	if err := stream.WriteVal(v); err != nil {
+		return nil, err
+	}
+	result := stream.Buffer()
+	copied := make([]byte, len(result))
+	copy(copied, result)
+	return copied, nil
+}
+
+func (cfg *frozenConfig) EncodingLength(v any) (int, error) {
+	stream := cfg.streamPool.BorrowStream(nil)
+	defer cfg.streamPool.ReturnStream(stream)
+	return stream.sizeOfVal(v)
+}
+
+func (cfg *frozenConfig) Unmarshal(data []byte, v any) error {
+	iter := cfg.iteratorPool.BorrowIterator(data)
+	defer cfg.iteratorPool.ReturnIterator(iter)
+	if err := iter.ReadVal(v); err != nil {
+		return err
+	}
+	_, err := iter.ReadByte()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	return newSyntaxError("there are bytes left after unmarshal", iter.numRead()-1)
+}
+
+func (cfg *frozenConfig) UnmarshalAt(data []byte, offset int, v any) error {
+	end, err := skipAt(data, offset)
+	if err != nil {
+		return err
+	}
+	iter := cfg.iteratorPool.BorrowIterator(data[offset:end])
+	defer cfg.iteratorPool.ReturnIterator(iter)
+	return addErrorOffset(iter.ReadVal(v), offset)
+}
+
+func (cfg *frozenConfig) UnmarshalPath(data []byte, v any, path ...any) error {
+	seeker, err := CompilePath(path...)
+	if err != nil {
+		return err
+	}
+	return cfg.UnmarshalSeek(data, seeker, v)
+}
+
+func (cfg *frozenConfig) UnmarshalSeek(data []byte, seeker *Seeker, v any) error {
+	offset, err := seeker.Seek(data, 0)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return errors.New("value not found")
+	}
+	return cfg.UnmarshalAt(data, offset, v)
+}
+
+func (cfg *frozenConfig) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		stream: newStream(cfg, w, encoderBufferSize),
+	}
+}
+
+func (cfg *frozenConfig) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		iter: newIterator(cfg).Reset(r),
+	}
+}
+
+func (cfg *frozenConfig) NewReader(r io.Reader) *Reader {
+	return &Reader{
+		iter: newIterator(cfg).Reset(r),
+	}
+}
+
+func (cfg *frozenConfig) NewReaderBytes(buf []byte) *Reader {
+	return &Reader{
+		iter: newIterator(cfg).ResetBytes(buf),
+	}
+}
@@ -1,6 +1,229 @@
 package bipf
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes malformed BIPF input.
+type SyntaxError struct {
+	// Offset is the offset in the input at which the problem was detected.
+	Offset int64
+
+	msg string
+	err error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.msg, e.Offset)
+}
+
+// Unwrap returns the underlying error, for example io.ErrUnexpectedEOF, if
+// there is one.
+func (e *SyntaxError) Unwrap() error {
+	return e.err
+}
+
+// UnmarshalTypeError describes a BIPF value which can't be stored in a Go
+// value of a specific type.
+type UnmarshalTypeError struct {
+	// Value is the kind of the BIPF value.
+	Value Kind
+
+	// Type is the type of the Go value in which the BIPF value couldn't be
+	// stored.
+	Type reflect.Type
+
+	// Offset is the offset in the input at which the BIPF value starts.
+	Offset int64
+
+	// Field is the path leading from the decoded value to the BIPF value
+	// made of keys and indexes, for example "content.mentions[2].link". It
+	// is empty if the decoded value itself couldn't be stored.
+	Field string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("can't unmarshal %s at offset %d into field %s of type %s", e.Value, e.Offset, e.Field, e.Type)
+	}
+	return fmt.Sprintf("can't unmarshal %s at offset %d into a value of type %s", e.Value, e.Offset, e.Type)
+}
+
+// UnknownFieldError is returned when decoding an OBJECT into a struct with
+// Config.DisallowUnknownFields set if a key of the OBJECT doesn't match any of
+// the fields of the struct.
+type UnknownFieldError struct {
+	// Key is the key which doesn't match any field.
+	Key string
+
+	// Type is the type of the struct.
+	Type reflect.Type
+
+	// Offset is the offset in the input at which the key starts.
+	Offset int64
+
+	// Field is the path leading from the decoded value to the OBJECT, as in
+	// the case of UnmarshalTypeError. It is empty if the OBJECT is the
+	// decoded value itself.
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("unknown field %q at offset %d in field %s of type %s", e.Key, e.Offset, e.Field, e.Type)
+	}
+	return fmt.Sprintf("unknown field %q at offset %d in %s", e.Key, e.Offset, e.Type)
+}
+
+// DuplicateFieldError is returned when decoding an OBJECT into a struct if
+// two keys of the OBJECT match the same field of the struct. See
+// Config.CaseSensitive and Config.DisallowDuplicateFields.
+type DuplicateFieldError struct {
+	// PreviousKey is the key which matched the field first.
+	PreviousKey string
+
+	// Key is the key which matched the field again.
+	Key string
+
+	// StructField is the name of the field of the struct.
+	StructField string
+
+	// Type is the type of the struct.
+	Type reflect.Type
+
+	// Offset is the offset in the input at which Key starts.
+	Offset int64
+
+	// Field is the path leading from the decoded value to the OBJECT, as in
+	// the case of UnmarshalTypeError. It is empty if the OBJECT is the
+	// decoded value itself.
+	Field string
+}
+
+func (e *DuplicateFieldError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("fields %q and %q at offset %d in field %s both match field %s of %s", e.PreviousKey, e.Key, e.Offset, e.Field, e.StructField, e.Type)
+	}
+	return fmt.Sprintf("fields %q and %q at offset %d both match field %s of %s", e.PreviousKey, e.Key, e.Offset, e.StructField, e.Type)
+}
+
+// UnsupportedTypeError is returned when encoding or decoding a Go value of a
+// type which can't be represented in BIPF, for example a channel or a
+// function.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported type %s", e.Type)
+}
+
+// UnsupportedValueError is returned when encoding a Go value which can't be
+// represented in BIPF, for example an integer which doesn't fit in an INT
+// when using IntegerPolicyError.
+type UnsupportedValueError struct {
+	Value reflect.Value
+
+	// Str describes the value and the reason why it isn't supported.
+	Str string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return fmt.Sprintf("unsupported value: %s", e.Str)
+}
+
+func newSyntaxError(msg string, offset uint64) *SyntaxError {
+	return &SyntaxError{
+		Offset: int64(offset),
+		msg:    msg,
+	}
+}
+
+func wrapSyntaxError(err error, msg string, offset uint64) *SyntaxError {
+	err = noEOF(err)
+	return &SyntaxError{
+		Offset: int64(offset),
+		msg:    msg + ": " + err.Error(),
+		err:    err,
+	}
+}
+
+func newUnmarshalTypeError(typ valueType, goType reflect.Type, offset uint64) *UnmarshalTypeError {
+	return &UnmarshalTypeError{
+		Value:  Kind(typ),
+		Type:   goType,
+		Offset: int64(offset),
+	}
+}
+
+// addErrorKey prepends a key to the field path of err if err carries one.
+func addErrorKey(err error, key string) error {
+	if field := errorField(err); field != nil {
+		*field = prependErrorPath(*field, key)
+	}
+	return err
+}
+
+// addErrorIndex prepends an index to the field path of err if err carries
+// one.
+func addErrorIndex(err error, i int) error {
+	if field := errorField(err); field != nil {
+		*field = prependErrorPath(*field, "["+strconv.Itoa(i)+"]")
+	}
+	return err
+}
+
+// errorField returns a pointer to the field path carried by err or nil if err
+// is an UnmarshalTypeError, an UnknownFieldError or a DuplicateFieldError.
+func errorField(err error) *string {
+	var typeErr *UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &typeErr.Field
+	}
+	var unknownErr *UnknownFieldError
+	if errors.As(err, &unknownErr) {
+		return &unknownErr.Field
+	}
+	var duplicateErr *DuplicateFieldError
+	if errors.As(err, &duplicateErr) {
+		return &duplicateErr.Field
+	}
+	return nil
+}
+
+func prependErrorPath(path, elem string) string {
+	if path == "" || strings.HasPrefix(path, "[") {
+		return elem + path
+	}
+	return elem + "." + path
+}
+
+// addErrorOffset shifts the offsets carried by err if err is a SyntaxError,
+// an UnmarshalTypeError, an UnknownFieldError or a DuplicateFieldError. It is
+// used when only a part of the input is decoded.
+func addErrorOffset(err error, offset int) error {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		syntaxErr.Offset += int64(offset)
+	}
+	var typeErr *UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		typeErr.Offset += int64(offset)
+	}
+	var unknownErr *UnknownFieldError
+	if errors.As(err, &unknownErr) {
+		unknownErr.Offset += int64(offset)
+	}
+	var duplicateErr *DuplicateFieldError
+	if errors.As(err, &duplicateErr) {
+		duplicateErr.Offset += int64(offset)
+	}
+	return err
+}
 
 func wrap(err error, message string) error {
 	if err == nil {
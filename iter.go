@@ -3,12 +3,15 @@ package bipf
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"reflect"
 )
 
-const maxDepth = 10000
+const readerBufferSize = 512
 
 type iterator struct {
+	cfg              *frozenConfig
 	reader           io.Reader
 	numOfReadBytes   int
 	buf              []byte
@@ -19,8 +22,9 @@ type iterator struct {
 	captured         []byte
 }
 
-func newIterator() *iterator {
+func newIterator(cfg *frozenConfig) *iterator {
 	return &iterator{
+		cfg:    cfg,
 		reader: nil,
 		buf:    nil,
 		head:   0,
@@ -31,6 +35,7 @@ func newIterator() *iterator {
 
 func (iter *iterator) Reset(reader io.Reader) *iterator {
 	iter.reader = reader
+	iter.buf = make([]byte, readerBufferSize)
 	iter.head = 0
 	iter.tail = 0
 	iter.depth = 0
@@ -51,7 +56,7 @@ func (iter *iterator) ResetBytes(input []byte) *iterator {
 func (iter *iterator) whatIsNext() (valueType, error) {
 	b, err := iter.ReadByte()
 	if err != nil {
-		return 0, err
+		return 0, iter.annotateEOF(err)
 	}
 	typ := b & 0x07
 	iter.unreadByte()
@@ -61,6 +66,9 @@ func (iter *iterator) whatIsNext() (valueType, error) {
 func (iter *iterator) readTag() (valueType, uint64, error) {
 	v, err := binary.ReadUvarint(iter)
 	if err != nil {
+		if err == io.EOF {
+			return 0, 0, iter.annotateEOF(err)
+		}
 		return 0, 0, iter.annotateError(wrapf(err, "error reading uvarint"))
 	}
 	typ := byte(v) & 0x07
@@ -69,13 +77,16 @@ func (iter *iterator) readTag() (valueType, uint64, error) {
 }
 
 func (iter *iterator) Read(b []byte) (n int, err error) {
-	for i := 0; i < len(b); i++ {
-		rb, err := iter.ReadByte()
-		if err != nil {
-			return n, err
+	for n < len(b) {
+		if iter.head == iter.tail {
+			if err := iter.loadMore(); err != nil {
+				return n, err
+			}
 		}
-		b[i] = rb
-		n++
+		copied := copy(b[n:], iter.buf[iter.head:iter.tail])
+		iter.head += copied
+		iter.numOfReadBytes += copied
+		n += copied
 	}
 	return n, nil
 }
@@ -96,26 +107,23 @@ func (iter *iterator) ReadByte() (ret byte, err error) {
 	return ret, nil
 }
 
+// annotateError converts err into a SyntaxError pointing at the current
+// offset.
 func (iter *iterator) annotateError(err error) error {
-	peekStart := iter.head - 10
-	if peekStart < 0 {
-		peekStart = 0
+	return &SyntaxError{
+		Offset: int64(iter.numRead()),
+		msg:    err.Error(),
+		err:    err,
 	}
-	peekEnd := iter.head + 10
-	if peekEnd > iter.tail {
-		peekEnd = iter.tail
-	}
-	parsing := string(iter.buf[peekStart:peekEnd])
-	contextStart := iter.head - 50
-	if contextStart < 0 {
-		contextStart = 0
-	}
-	contextEnd := iter.head + 50
-	if contextEnd > iter.tail {
-		contextEnd = iter.tail
+}
+
+// annotateEOF converts io.EOF, which is returned when the input ends in the
+// middle of a value, into a SyntaxError wrapping io.ErrUnexpectedEOF.
+func (iter *iterator) annotateEOF(err error) error {
+	if err == io.EOF {
+		return iter.annotateError(io.ErrUnexpectedEOF)
 	}
-	context := string(iter.buf[contextStart:contextEnd])
-	return wrapf(err, "error found in #%v byte of ...|%x|..., bigger context ...|%x|...", iter.head-peekStart, parsing, context)
+	return err
 }
 
 func (iter *iterator) loadMore() error {
@@ -142,11 +150,36 @@ func (iter *iterator) loadMore() error {
 	}
 }
 
+// more reports whether there is any more input left to read.
+func (iter *iterator) more() (bool, error) {
+	if iter.head != iter.tail {
+		return true, nil
+	}
+	if err := iter.loadMore(); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (iter *iterator) unreadByte() {
 	iter.numOfReadBytes--
 	iter.head--
 }
 
+// unreadBytes moves back by n bytes. It reports false if that isn't possible
+// as some of the bytes were read before the buffer was refilled.
+func (iter *iterator) unreadBytes(n int) bool {
+	if iter.head < n {
+		return false
+	}
+	iter.numOfReadBytes -= n
+	iter.head -= n
+	return true
+}
+
 func (iter *iterator) ReadAny() (any, error) {
 	valueType, err := iter.whatIsNext()
 	if err != nil {
@@ -159,8 +192,14 @@ func (iter *iterator) ReadAny() (any, error) {
 	case valueTypeBuffer:
 		return iter.ReadBuffer()
 	case valueTypeInt:
-		return iter.ReadInt32()
+		if iter.cfg.useNumber {
+			return iter.readNumber()
+		}
+		return iter.readIntAny()
 	case valueTypeDouble:
+		if iter.cfg.useNumber {
+			return iter.readNumber()
+		}
 		return iter.ReadFloat64()
 	case valueTypeArray:
 		var arr []any
@@ -176,44 +215,109 @@ func (iter *iterator) ReadAny() (any, error) {
 		}
 		return arr, nil
 	case valueTypeObject:
-		obj := make(map[any]any)
-		if err := iter.ReadObjectCB(func(Iter *iterator) error {
-			var key any
-			if err := iter.ReadVal(&key); err != nil {
-				return err
-			}
-
-			var value any
-			if err := iter.ReadVal(&value); err != nil {
-				return err
-			}
-
-			obj[key] = value
-			return nil
-		}); err != nil {
-			return nil, err
+		if iter.cfg.objectRepresentation == ObjectRepresentationAnyMap {
+			return iter.readAnyMap()
 		}
-		return obj, nil
+		return iter.readStringMap()
 	case valueTypeBoolNull:
 		ok, err := iter.CheckNilIsNext()
 		if err != nil {
 			return nil, err
 		}
 		if ok {
-			return nil, iter.ReadNil()
+			return nil, nil
 		}
 		return iter.ReadBool()
+	case valueTypeExtended:
+		return iter.readExtendedAny()
 	default:
 		return nil, iter.annotateError(errors.New("unsupported type"))
 	}
 }
 
+func (iter *iterator) readIntAny() (any, error) {
+	v, err := iter.ReadInt32()
+	if err != nil {
+		return nil, err
+	}
+	switch iter.cfg.intRepresentation {
+	case IntRepresentationInt:
+		return int(v), nil
+	case IntRepresentationInt64:
+		return int64(v), nil
+	default:
+		return v, nil
+	}
+}
+
+func (iter *iterator) readAnyMap() (map[any]any, error) {
+	obj := make(map[any]any)
+	if err := iter.ReadObjectCB(func(iter *iterator) error {
+		var key any
+		if err := iter.ReadVal(&key); err != nil {
+			return err
+		}
+
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return iter.annotateError(fmt.Errorf("key of type %T can't be used as a map key", key))
+		}
+
+		var value any
+		if err := iter.ReadVal(&value); err != nil {
+			return err
+		}
+
+		obj[key] = value
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (iter *iterator) readStringMap() (map[string]any, error) {
+	obj := make(map[string]any)
+	if err := iter.ReadObjectCB(func(iter *iterator) error {
+		var key string
+		keyType, err := iter.whatIsNext()
+		if err != nil {
+			return err
+		}
+		if keyType == valueTypeString {
+			key, err = iter.ReadString()
+			if err != nil {
+				return err
+			}
+		} else {
+			if iter.cfg.objectRepresentation != ObjectRepresentationStringMapStringifyKeys {
+				return iter.annotateError(fmt.Errorf("key of type %s isn't a STRING", keyType))
+			}
+			var anyKey any
+			if err := iter.ReadVal(&anyKey); err != nil {
+				return err
+			}
+			key = fmt.Sprint(anyKey)
+		}
+
+		var value any
+		if err := iter.ReadVal(&value); err != nil {
+			return err
+		}
+
+		obj[key] = value
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
 func (iter *iterator) incrementDepth() error {
 	iter.depth++
-	if iter.depth <= maxDepth {
+	if iter.depth <= iter.cfg.maxDepth {
 		return nil
 	}
-	return errors.New("exceeded max depth")
+	return iter.annotateError(errors.New("exceeded max depth"))
 }
 
 func (iter *iterator) decrementDepth() error {
@@ -234,27 +338,84 @@ func (iter *iterator) skip() error {
 		return err
 	}
 
-	for i := 0; i < int(l); i++ {
+	return iter.skipBytes(l)
+}
+
+func (iter *iterator) skipBytes(n uint64) error {
+	for i := uint64(0); i < n; i++ {
 		_, err := iter.ReadByte()
 		if err != nil {
-			return err
+			return iter.annotateEOF(err)
 		}
 	}
 
 	return nil
 }
 
+// readBytes reads the next l bytes. The lengths of payloads come from
+// untrusted input, therefore l isn't used to allocate memory upfront unless
+// it is known that the input holds that many bytes.
+func (iter *iterator) readBytes(l uint64) ([]byte, error) {
+	if iter.reader == nil {
+		if l > uint64(iter.tail-iter.head) {
+			return nil, iter.annotateError(io.ErrUnexpectedEOF)
+		}
+		b := make([]byte, l)
+		_, err := iter.Read(b)
+		return b, err
+	}
+
+	initialCapacity := l
+	if initialCapacity > readerBufferSize {
+		initialCapacity = readerBufferSize
+	}
+
+	b := make([]byte, 0, initialCapacity)
+	for uint64(len(b)) < l {
+		if iter.head == iter.tail {
+			if err := iter.loadMore(); err != nil {
+				return nil, iter.annotateEOF(err)
+			}
+		}
+		n := iter.tail - iter.head
+		if remaining := l - uint64(len(b)); uint64(n) > remaining {
+			n = int(remaining)
+		}
+		b = append(b, iter.buf[iter.head:iter.head+n]...)
+		iter.head += n
+		iter.numOfReadBytes += n
+	}
+	return b, nil
+}
+
+// readSharedBytes reads the next l bytes. If Config.ZeroCopy is set and the
+// input is a byte slice then the returned slice refers to the input instead
+// of being a copy. The capacity of the returned slice is limited so that
+// appending to it doesn't modify the input.
+func (iter *iterator) readSharedBytes(l uint64) ([]byte, error) {
+	if iter.reader != nil || !iter.cfg.zeroCopy {
+		return iter.readBytes(l)
+	}
+	if l > uint64(iter.tail-iter.head) {
+		return nil, iter.annotateError(io.ErrUnexpectedEOF)
+	}
+	end := iter.head + int(l)
+	b := iter.buf[iter.head:end:end]
+	iter.head = end
+	iter.numOfReadBytes += int(l)
+	return b, nil
+}
+
 func (iter *iterator) ReadBuffer() ([]byte, error) {
+	offset := iter.numRead()
 	v, l, err := iter.readTag()
 	if err != nil {
 		return nil, err
 	}
 
 	if v != valueTypeBuffer {
-		return nil, errors.New("unexpected type")
+		return nil, newUnmarshalTypeError(v, reflect.TypeOf([]byte(nil)), offset)
 	}
 
-	b := make([]byte, int(l))
-	_, err = iter.Read(b)
-	return b, err
+	return iter.readSharedBytes(l)
 }
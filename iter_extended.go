@@ -0,0 +1,50 @@
+package bipf
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+)
+
+// ReadExtended reads an EXTENDED value and returns its subtype and the data
+// which follows it.
+func (iter *iterator) ReadExtended() (uint64, []byte, error) {
+	return iter.readExtended(reflect.TypeOf(Extended{}))
+}
+
+// readExtended reads an EXTENDED value. The type of the Go value in which the
+// EXTENDED value will be stored is used in errors.
+func (iter *iterator) readExtended(goType reflect.Type) (uint64, []byte, error) {
+	offset := iter.numRead()
+	typ, l, err := iter.readTag()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if typ != valueTypeExtended {
+		return 0, nil, newUnmarshalTypeError(typ, goType, offset)
+	}
+
+	return iter.readExtendedPayload(l)
+}
+
+func (iter *iterator) readExtendedPayload(l uint64) (uint64, []byte, error) {
+	payload, err := iter.readBytes(l)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	subtype, data, err := parseExtendedPayload(payload)
+	if err != nil {
+		return 0, nil, iter.annotateError(err)
+	}
+	return subtype, data, nil
+}
+
+func parseExtendedPayload(payload []byte) (uint64, []byte, error) {
+	subtype, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return 0, nil, errors.New("error reading the subtype of an EXTENDED value")
+	}
+	return subtype, payload[n:], nil
+}
@@ -4,40 +4,50 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"reflect"
 )
 
 func (iter *iterator) ReadFloat32() (float32, error) {
-	v, err := iter.ReadFloat64()
+	offset := iter.numRead()
+	v, err := iter.readFloat64(reflect.TypeOf(float32(0)))
 	if err != nil {
 		return 0, err
 	}
-	if v > math.MaxFloat32 {
-		return 0, errors.New("overflow")
-	}
-	if v < math.SmallestNonzeroFloat32 {
-		return 0, errors.New("underflow")
+	if math.Abs(v) > math.MaxFloat32 && !math.IsInf(v, 0) {
+		return 0, newUnmarshalTypeError(valueTypeDouble, reflect.TypeOf(float32(0)), offset)
 	}
 	return float32(v), nil
 }
 
 func (iter *iterator) ReadFloat64() (float64, error) {
+	return iter.readFloat64(reflect.TypeOf(float64(0)))
+}
+
+// readFloat64 reads a DOUBLE. The type of the Go value in which the DOUBLE
+// will be stored is used in errors.
+func (iter *iterator) readFloat64(goType reflect.Type) (float64, error) {
+	offset := iter.numRead()
 	v, l, err := iter.readTag()
 	if err != nil {
 		return 0, err
 	}
 
-	if l != 8 {
-		return 0, errors.New("invalid length")
+	if v != valueTypeDouble {
+		return 0, newUnmarshalTypeError(v, goType, offset)
 	}
 
-	if v != valueTypeDouble {
-		return 0, errors.New("invalid type")
+	return iter.readFloat64Payload(l)
+}
+
+func (iter *iterator) readFloat64Payload(l uint64) (float64, error) {
+	if l != 8 {
+		return 0, iter.annotateError(errors.New("invalid length of DOUBLE"))
 	}
 
 	buf := make([]byte, 8)
-	_, err = iter.Read(buf)
+	_, err := iter.Read(buf)
 	if err != nil {
-		return 0, err
+		return 0, iter.annotateEOF(err)
 	}
 
 	u := binary.LittleEndian.Uint64(buf)
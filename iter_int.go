@@ -4,82 +4,67 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"reflect"
 )
 
 func (iter *iterator) ReadInt8() (int8, error) {
-	val, err := iter.ReadInt32()
-	if err != nil {
-		return 0, err
-	}
-	if val > math.MaxInt8 {
-		return 0, errors.New("overflow")
-	}
-	if val < math.MinInt8 {
-		return 0, errors.New("underflow")
-	}
-	return int8(val), nil
+	val, err := iter.readInt32(math.MinInt8, math.MaxInt8, reflect.TypeOf(int8(0)))
+	return int8(val), err
 }
 
-func (iter *iterator) ReadUint8() (ret uint8, err error) {
-	val, err := iter.ReadInt32()
-	if err != nil {
-		return 0, err
-	}
-	if val > math.MaxUint8 {
-		return 0, errors.New("overflow")
-	}
-	if val < 0 {
-		return 0, errors.New("underflow")
-	}
-	return uint8(val), nil
+func (iter *iterator) ReadUint8() (uint8, error) {
+	val, err := iter.readInt32(0, math.MaxUint8, reflect.TypeOf(uint8(0)))
+	return uint8(val), err
 }
 
 func (iter *iterator) ReadInt16() (int16, error) {
-	val, err := iter.ReadInt32()
-	if err != nil {
-		return 0, err
-	}
-	if val > math.MaxInt16 {
-		return 0, errors.New("overflow")
-	}
-	if val < math.MinInt16 {
-		return 0, errors.New("underflow")
-	}
-	return int16(val), nil
+	val, err := iter.readInt32(math.MinInt16, math.MaxInt16, reflect.TypeOf(int16(0)))
+	return int16(val), err
 }
 
 func (iter *iterator) ReadUint16() (uint16, error) {
-	val, err := iter.ReadInt32()
+	val, err := iter.readInt32(0, math.MaxUint16, reflect.TypeOf(uint16(0)))
+	return uint16(val), err
+}
+
+func (iter *iterator) ReadInt32() (int32, error) {
+	return iter.readInt32(math.MinInt32, math.MaxInt32, reflect.TypeOf(int32(0)))
+}
+
+// readInt32 reads an INT which must be in the range [min, max]. The type of
+// the Go value in which the INT will be stored is used in errors.
+func (iter *iterator) readInt32(min, max int32, goType reflect.Type) (int32, error) {
+	offset := iter.numRead()
+	v, l, err := iter.readTag()
 	if err != nil {
 		return 0, err
 	}
-	if val > math.MaxUint16 {
-		return 0, errors.New("overflow")
-	}
-	if val < 0 {
-		return 0, errors.New("underflow")
+
+	if v != valueTypeInt {
+		return 0, newUnmarshalTypeError(v, goType, offset)
 	}
-	return uint16(val), nil
-}
 
-func (iter *iterator) ReadInt32() (ret int32, err error) {
-	v, l, err := iter.readTag()
+	val, err := iter.readInt32Payload(l)
 	if err != nil {
 		return 0, err
 	}
 
-	if l != 4 {
-		return 0, errors.New("invalid length")
+	if val < min || val > max {
+		return 0, newUnmarshalTypeError(v, goType, offset)
 	}
 
-	if v != valueTypeInt {
-		return 0, errors.New("invalid type")
+	return val, nil
+}
+
+func (iter *iterator) readInt32Payload(l uint64) (int32, error) {
+	if l != 4 {
+		return 0, iter.annotateError(errors.New("invalid length of INT"))
 	}
 
 	buf := make([]byte, 4)
-	_, err = iter.Read(buf)
+	_, err := iter.Read(buf)
 	if err != nil {
-		return 0, err
+		return 0, iter.annotateEOF(err)
 	}
 
 	u := binary.LittleEndian.Uint32(buf)
@@ -87,22 +72,122 @@ func (iter *iterator) ReadInt32() (ret int32, err error) {
 }
 
 func (iter *iterator) ReadUint32() (uint32, error) {
-	val, err := iter.ReadInt32()
+	val, err := iter.readUint64(math.MaxUint32, reflect.TypeOf(uint32(0)))
+	return uint32(val), err
+}
+
+// ReadInt64 reads an integer encoded as an INT, as a DOUBLE holding an integer
+// or as an EXTENDED value of one of the subtypes used by IntegerPolicyExtended.
+func (iter *iterator) ReadInt64() (int64, error) {
+	goType := reflect.TypeOf(int64(0))
+
+	offset := iter.numRead()
+	v, l, err := iter.readTag()
 	if err != nil {
 		return 0, err
 	}
-	if val < 0 {
-		return 0, errors.New("underflow")
+
+	switch v {
+	case valueTypeInt:
+		val, err := iter.readInt32Payload(l)
+		return int64(val), err
+	case valueTypeDouble:
+		f, err := iter.readFloat64Payload(l)
+		if err != nil {
+			return 0, err
+		}
+		if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+		return int64(f), nil
+	case valueTypeExtended:
+		subtype, data, err := iter.readExtendedPayload(l)
+		if err != nil {
+			return 0, err
+		}
+		isInteger, signed := iter.cfg.extendedInteger(subtype)
+		if !isInteger {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+		val, err := parseExtendedInteger(data)
+		if err != nil {
+			return 0, iter.annotateError(err)
+		}
+		if !signed && val > math.MaxInt64 {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+		return int64(val), nil
+	default:
+		return 0, newUnmarshalTypeError(v, goType, offset)
 	}
-	return uint32(val), nil
 }
 
-func (iter *iterator) ReadInt64() (int64, error) {
-	val, err := iter.ReadInt32()
-	return int64(val), err
+// ReadUint64 reads an integer encoded in the same ways as in the case of
+// ReadInt64.
+func (iter *iterator) ReadUint64() (uint64, error) {
+	return iter.readUint64(math.MaxUint64, reflect.TypeOf(uint64(0)))
 }
 
-func (iter *iterator) ReadUint64() (uint64, error) {
-	val, err := iter.ReadInt32()
-	return uint64(val), err
+// readUint64 reads an integer encoded in the same ways as in the case of
+// ReadInt64 which must not be larger than max. The type of the Go value in
+// which the integer will be stored is used in errors.
+func (iter *iterator) readUint64(max uint64, goType reflect.Type) (uint64, error) {
+	offset := iter.numRead()
+	v, l, err := iter.readTag()
+	if err != nil {
+		return 0, err
+	}
+
+	var val uint64
+	switch v {
+	case valueTypeInt:
+		i, err := iter.readInt32Payload(l)
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+		val = uint64(i)
+	case valueTypeDouble:
+		f, err := iter.readFloat64Payload(l)
+		if err != nil {
+			return 0, err
+		}
+		if f != math.Trunc(f) || f < 0 || f >= 1<<64 {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+		val = uint64(f)
+	case valueTypeExtended:
+		subtype, data, err := iter.readExtendedPayload(l)
+		if err != nil {
+			return 0, err
+		}
+		isInteger, signed := iter.cfg.extendedInteger(subtype)
+		if !isInteger {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+		val, err = parseExtendedInteger(data)
+		if err != nil {
+			return 0, iter.annotateError(err)
+		}
+		if signed && int64(val) < 0 {
+			return 0, newUnmarshalTypeError(v, goType, offset)
+		}
+	default:
+		return 0, newUnmarshalTypeError(v, goType, offset)
+	}
+
+	if val > max {
+		return 0, newUnmarshalTypeError(v, goType, offset)
+	}
+
+	return val, nil
+}
+
+func parseExtendedInteger(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, errors.New("invalid length of EXTENDED integer")
+	}
+	return binary.LittleEndian.Uint64(data), nil
 }
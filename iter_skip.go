@@ -2,12 +2,13 @@ package bipf
 
 import (
 	"errors"
+	"reflect"
 )
 
 func (iter *iterator) CheckNilIsNext() (bool, error) {
 	b, err := iter.ReadByte()
 	if err != nil {
-		return false, err
+		return false, iter.annotateEOF(err)
 	}
 
 	if b == byte(valueTypeBoolNull) && b>>3 == 0 {
@@ -21,33 +22,34 @@ func (iter *iterator) CheckNilIsNext() (bool, error) {
 func (iter *iterator) ReadNil() error {
 	b, err := iter.ReadByte()
 	if err != nil {
-		return err
+		return iter.annotateEOF(err)
 	}
 
 	if b == byte(valueTypeBoolNull) && b>>3 == 0 {
 		return nil
 	}
 
-	return errors.New("this value isn't a nil")
+	return iter.annotateError(errors.New("this value isn't a nil"))
 }
 
 func (iter *iterator) ReadBool() (bool, error) {
+	offset := iter.numRead()
 	v, l, err := iter.readTag()
 	if err != nil {
 		return false, err
 	}
 
-	if v != valueTypeBoolNull {
-		return false, errors.New("invalid type")
+	if v != valueTypeBoolNull || l == 0 {
+		return false, newUnmarshalTypeError(v, reflect.TypeOf(false), offset)
 	}
 
 	if l != 1 {
-		return false, errors.New("invalid length")
+		return false, iter.annotateError(errors.New("invalid length of BOOLNULL"))
 	}
 
 	b, err := iter.ReadByte()
 	if err != nil {
-		return false, err
+		return false, iter.annotateEOF(err)
 	}
 
 	switch b {
@@ -56,6 +58,38 @@ func (iter *iterator) ReadBool() (bool, error) {
 	case 0x01:
 		return true, nil
 	default:
-		return false, errors.New("invalid bool value")
+		return false, iter.annotateError(errors.New("invalid bool value"))
+	}
+}
+
+// skipAndReturnBytes skips the next value and returns its encoding, tag
+// included.
+func (iter *iterator) skipAndReturnBytes() ([]byte, error) {
+	iter.startCapture(iter.head)
+	err := iter.skip()
+	captured := iter.stopCapture()
+	if err != nil {
+		return nil, err
+	}
+	return captured, nil
+}
+
+func (iter *iterator) startCapture(captureStartedAt int) {
+	iter.captureStartedAt = captureStartedAt
+	iter.captured = make([]byte, 0, 32)
+}
+
+func (iter *iterator) stopCapture() []byte {
+	captured := append(iter.captured, iter.buf[iter.captureStartedAt:iter.head]...)
+	iter.captured = nil
+	return captured
+}
+
+// readPayload reads the next value and returns its payload without the tag.
+func (iter *iterator) readPayload() ([]byte, error) {
+	_, l, err := iter.readTag()
+	if err != nil {
+		return nil, err
 	}
+	return iter.readBytes(l)
 }
@@ -1,22 +1,45 @@
 package bipf
 
 import (
-	"errors"
+	"reflect"
+	"unsafe"
 )
 
 func (iter *iterator) ReadString() (string, error) {
+	offset := iter.numRead()
 	typ, length, err := iter.readTag()
 	if err != nil {
 		return "", err
 	}
 	if typ != valueTypeString {
-		return "", errors.New("expected a string")
+		return "", newUnmarshalTypeError(typ, reflect.TypeOf(""), offset)
 	}
 
-	str := make([]byte, length)
-	_, err = iter.Read(str)
+	if iter.reader == nil && iter.cfg.zeroCopy {
+		str, err := iter.readSharedBytes(length)
+		if err != nil {
+			return "", err
+		}
+		return unsafe.String(unsafe.SliceData(str), len(str)), nil
+	}
+	return iter.readStringPayload(length)
+}
+
+// readStringPayload reads the next l bytes as a string. If all of them are
+// buffered then they are copied into the string directly. Otherwise they are
+// read into a new slice which nothing else refers to, therefore the string
+// can use it without copying it again.
+func (iter *iterator) readStringPayload(l uint64) (string, error) {
+	if l <= uint64(iter.tail-iter.head) {
+		end := iter.head + int(l)
+		str := string(iter.buf[iter.head:end])
+		iter.head = end
+		iter.numOfReadBytes += int(l)
+		return str, nil
+	}
+	b, err := iter.readBytes(l)
 	if err != nil {
 		return "", err
 	}
-	return string(str), nil
+	return unsafe.String(unsafe.SliceData(b), len(b)), nil
 }
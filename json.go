@@ -0,0 +1,731 @@
+package bipf
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const ssbBufferSuffix = ".sha256"
+
+// ToJSON converts a single BIPF-encoded value to JSON and writes it to dst.
+// The value isn't decoded into Go values, therefore the order of the keys of
+// OBJECTs is preserved.
+//
+// STRINGs, INTs, DOUBLEs, ARRAYs, OBJECTs and BOOLNULLs are converted to
+// their JSON equivalents. BUFFERs are converted to JSON strings as determined
+// by Config.JSONBufferRepresentation. EXTENDED values holding integers
+// encoded according to IntegerPolicyExtended are converted to JSON numbers.
+// An error is returned if a key of an OBJECT isn't a STRING, if a DOUBLE is
+// not a finite number or if the value contains any other EXTENDED value.
+func ToJSON(dst io.Writer, src []byte) error {
+	return ConfigDefault.ToJSON(dst, src)
+}
+
+// FromJSON converts a single JSON value read from src to BIPF and writes it
+// to dst. The value isn't decoded into Go values, therefore the order of the
+// keys of JSON objects is preserved.
+//
+// JSON numbers which are integers that fit in an int32 are converted to INTs.
+// Other integers are encoded according to Config.IntegerPolicy in the same way
+// as int64 and uint64 values are encoded by Marshal, an error is returned if
+// an integer can't be encoded exactly. The remaining numbers, including -0,
+// are converted to DOUBLEs. JSON strings are converted to STRINGs or,
+// depending on Config.JSONBufferRepresentation, to BUFFERs.
+func FromJSON(dst io.Writer, src io.Reader) error {
+	return ConfigDefault.FromJSON(dst, src)
+}
+
+func (cfg *frozenConfig) ToJSON(dst io.Writer, src []byte) error {
+	iter := cfg.iteratorPool.BorrowIterator(src)
+	defer cfg.iteratorPool.ReturnIterator(iter)
+	stream := cfg.streamPool.BorrowStream(dst)
+	defer cfg.streamPool.ReturnStream(stream)
+
+	if err := iter.transcodeToJSON(stream); err != nil {
+		return noEOF(err)
+	}
+
+	more, err := iter.more()
+	if err != nil {
+		return err
+	}
+	if more {
+		return errors.New("there are bytes left after the value")
+	}
+
+	return stream.Flush()
+}
+
+func (iter *iterator) transcodeToJSON(stream *stream) error {
+	typ, l, err := iter.readTag()
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case valueTypeString:
+		b, err := iter.readBytes(l)
+		if err != nil {
+			return err
+		}
+		stream.buf = appendJSONString(stream.buf, b)
+	case valueTypeBuffer:
+		b, err := iter.readBytes(l)
+		if err != nil {
+			return err
+		}
+		stream.buf = append(stream.buf, '"')
+		stream.buf = appendBase64(stream.buf, b)
+		if iter.cfg.jsonBufferRepresentation == BufferRepresentationSSB {
+			stream.buf = append(stream.buf, ssbBufferSuffix...)
+		}
+		stream.buf = append(stream.buf, '"')
+	case valueTypeInt:
+		v, err := iter.readInt32Payload(l)
+		if err != nil {
+			return err
+		}
+		stream.buf = strconv.AppendInt(stream.buf, int64(v), 10)
+	case valueTypeDouble:
+		v, err := iter.readFloat64Payload(l)
+		if err != nil {
+			return err
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("DOUBLE %v can't be represented in JSON", v)
+		}
+		stream.buf = appendJSONFloat(stream.buf, v)
+	case valueTypeArray, valueTypeObject:
+		return iter.transcodeContainerToJSON(stream, typ, l)
+	case valueTypeExtended:
+		subtype, data, err := iter.readExtendedPayload(l)
+		if err != nil {
+			return err
+		}
+		isInteger, signed := iter.cfg.extendedInteger(subtype)
+		if !isInteger {
+			return iter.annotateError(fmt.Errorf("%s of subtype %d can't be represented in JSON", typ, subtype))
+		}
+		v, err := parseExtendedInteger(data)
+		if err != nil {
+			return iter.annotateError(err)
+		}
+		if signed {
+			stream.buf = strconv.AppendInt(stream.buf, int64(v), 10)
+		} else {
+			stream.buf = strconv.AppendUint(stream.buf, v, 10)
+		}
+	case valueTypeBoolNull:
+		b, err := iter.readBytes(l)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(b) == 0:
+			stream.buf = append(stream.buf, "null"...)
+		case len(b) == 1 && b[0] == 0:
+			stream.buf = append(stream.buf, "false"...)
+		case len(b) == 1 && b[0] == 1:
+			stream.buf = append(stream.buf, "true"...)
+		default:
+			return iter.annotateError(errors.New("invalid BOOLNULL value"))
+		}
+	default:
+		return iter.annotateError(fmt.Errorf("%s can't be represented in JSON", typ))
+	}
+
+	return nil
+}
+
+func (iter *iterator) transcodeContainerToJSON(stream *stream, typ valueType, l uint64) error {
+	if err := iter.incrementDepth(); err != nil {
+		return err
+	}
+
+	open, closing := byte('['), byte(']')
+	if typ == valueTypeObject {
+		open, closing = '{', '}'
+	}
+
+	stream.buf = append(stream.buf, open)
+
+	start := iter.numRead()
+	for i := 0; iter.numRead()-start < l; i++ {
+		if i > 0 {
+			stream.buf = append(stream.buf, ',')
+		}
+
+		if typ == valueTypeObject {
+			keyTyp, keyLength, err := iter.readTag()
+			if err != nil {
+				return err
+			}
+			if keyTyp != valueTypeString {
+				return iter.annotateError(fmt.Errorf("key of type %s can't be represented in JSON", keyTyp))
+			}
+			key, err := iter.readBytes(keyLength)
+			if err != nil {
+				return err
+			}
+			stream.buf = appendJSONString(stream.buf, key)
+			stream.buf = append(stream.buf, ':')
+		}
+
+		if err := iter.transcodeToJSON(stream); err != nil {
+			return err
+		}
+	}
+
+	if iter.numRead()-start > l {
+		return iter.annotateError(errors.New("out of bounds"))
+	}
+
+	stream.buf = append(stream.buf, closing)
+
+	return iter.decrementDepth()
+}
+
+func appendBase64(buf []byte, b []byte) []byte {
+	n := len(buf)
+	buf = append(buf, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+	base64.StdEncoding.Encode(buf[n:], b)
+	return buf
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a quoted JSON string. Invalid UTF-8 is
+// replaced with U+FFFD.
+func appendJSONString(buf []byte, s []byte) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			switch {
+			case b == '"' || b == '\\':
+				buf = append(buf, '\\', b)
+			case b == '\n':
+				buf = append(buf, '\\', 'n')
+			case b == '\r':
+				buf = append(buf, '\\', 'r')
+			case b == '\t':
+				buf = append(buf, '\\', 't')
+			case b < 0x20:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			default:
+				buf = append(buf, b)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, `�`...)
+		} else {
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(buf, '"')
+}
+
+// appendJSONFloat appends f formatted in the same way as encoding/json
+// formats float64 values.
+func appendJSONFloat(buf []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
+
+func (cfg *frozenConfig) FromJSON(dst io.Writer, src io.Reader) error {
+	scratch := cfg.streamPool.BorrowStream(nil)
+	defer cfg.streamPool.ReturnStream(scratch)
+
+	t := &jsonTranscoder{
+		cfg:     cfg,
+		iter:    newIterator(cfg).Reset(src),
+		scratch: scratch,
+	}
+
+	c, err := t.nextToken()
+	if err != nil {
+		return noEOF(err)
+	}
+
+	if err := t.transcodeValue(c, 0); err != nil {
+		return noEOF(err)
+	}
+
+	if _, err := t.nextToken(); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return err
+		}
+		return t.syntaxError("there are bytes left after the value")
+	}
+
+	stream := cfg.streamPool.BorrowStream(dst)
+	defer cfg.streamPool.ReturnStream(stream)
+
+	t.writeTo(stream)
+	return stream.Flush()
+}
+
+// jsonTranscoder converts JSON to BIPF in a single pass over the input. The
+// tag of a container depends on the length of its payload which is only known
+// once the container is closed. Therefore values are written to a scratch
+// buffer without the tags of containers, which are recorded in tags and
+// inserted when the scratch buffer is copied to the output.
+type jsonTranscoder struct {
+	cfg     *frozenConfig
+	iter    *iterator
+	scratch *stream
+	tags    pendingTags
+
+	// str holds the contents of the string or the number which is being
+	// read.
+	str []byte
+}
+
+// writeTo writes the scratch buffer to stream inserting the tags of the
+// containers.
+func (t *jsonTranscoder) writeTo(stream *stream) {
+	stream.buf = t.tags.appendTo(stream.buf, t.scratch.Buffer())
+}
+
+func (t *jsonTranscoder) syntaxError(msg string) error {
+	return fmt.Errorf("%s at offset %d of JSON input", msg, t.iter.numRead())
+}
+
+// nextToken returns the first byte which isn't whitespace.
+func (t *jsonTranscoder) nextToken() (byte, error) {
+	for {
+		c, err := t.iter.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return c, nil
+		}
+	}
+}
+
+// expectToken reads the next token and checks that it is equal to expected.
+func (t *jsonTranscoder) expectToken(expected byte) error {
+	c, err := t.nextToken()
+	if err != nil {
+		return err
+	}
+	if c != expected {
+		return t.syntaxError(fmt.Sprintf("expected %q but found %q", expected, c))
+	}
+	return nil
+}
+
+// transcodeValue transcodes a value which starts with c.
+func (t *jsonTranscoder) transcodeValue(c byte, depth int) error {
+	switch c {
+	case '{':
+		return t.transcodeContainer(valueTypeObject, '}', depth)
+	case '[':
+		return t.transcodeContainer(valueTypeArray, ']', depth)
+	case '"':
+		if err := t.readString(); err != nil {
+			return err
+		}
+		if t.cfg.jsonBufferRepresentation == BufferRepresentationSSB {
+			if b, ok := parseSSBBuffer(string(t.str)); ok {
+				return t.scratch.WriteBuffer(b)
+			}
+		}
+		t.scratch.WriteTag(uint64(len(t.str)), valueTypeString)
+		t.scratch.buf = append(t.scratch.buf, t.str...)
+		return nil
+	case 't':
+		if err := t.readLiteral("rue"); err != nil {
+			return err
+		}
+		return t.scratch.WriteBool(true)
+	case 'f':
+		if err := t.readLiteral("alse"); err != nil {
+			return err
+		}
+		return t.scratch.WriteBool(false)
+	case 'n':
+		if err := t.readLiteral("ull"); err != nil {
+			return err
+		}
+		t.scratch.WriteNil()
+		return nil
+	default:
+		if c == '-' || (c >= '0' && c <= '9') {
+			return t.transcodeNumber(c)
+		}
+		return t.syntaxError(fmt.Sprintf("invalid character %q looking for beginning of value", c))
+	}
+}
+
+func (t *jsonTranscoder) transcodeContainer(typ valueType, closing byte, depth int) error {
+	if depth >= t.cfg.maxDepth {
+		return t.syntaxError("exceeded max depth")
+	}
+
+	index := t.tags.open(t.scratch.Buffered(), typ)
+
+	c, err := t.nextToken()
+	if err != nil {
+		return err
+	}
+
+	if c != closing {
+		for {
+			if typ == valueTypeObject {
+				if c != '"' {
+					return t.syntaxError(fmt.Sprintf("invalid character %q looking for beginning of object key", c))
+				}
+				if err := t.readString(); err != nil {
+					return err
+				}
+				t.scratch.WriteTag(uint64(len(t.str)), valueTypeString)
+				t.scratch.buf = append(t.scratch.buf, t.str...)
+
+				if err := t.expectToken(':'); err != nil {
+					return err
+				}
+				if c, err = t.nextToken(); err != nil {
+					return err
+				}
+			}
+
+			if err := t.transcodeValue(c, depth+1); err != nil {
+				return err
+			}
+
+			if c, err = t.nextToken(); err != nil {
+				return err
+			}
+			if c == closing {
+				break
+			}
+			if c != ',' {
+				return t.syntaxError(fmt.Sprintf("invalid character %q after %s element", c, typ))
+			}
+			if c, err = t.nextToken(); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.tags.close(index, t.scratch.Buffered())
+	return nil
+}
+
+func (t *jsonTranscoder) readLiteral(rest string) error {
+	for i := 0; i < len(rest); i++ {
+		c, err := t.iter.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != rest[i] {
+			return t.syntaxError(fmt.Sprintf("invalid character %q in literal", c))
+		}
+	}
+	return nil
+}
+
+// transcodeNumber transcodes a number which starts with c. Integers other
+// than -0 are encoded in the same way as int64 and uint64 values and other
+// numbers are converted to DOUBLEs.
+func (t *jsonTranscoder) transcodeNumber(c byte) error {
+	t.str = append(t.str[:0], c)
+	for {
+		c, err := t.iter.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if (c < '0' || c > '9') && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			t.iter.unreadByte()
+			break
+		}
+		t.str = append(t.str, c)
+	}
+
+	if !isValidJSONNumber(t.str) {
+		return t.syntaxError(fmt.Sprintf("invalid number %q", t.str))
+	}
+
+	s := string(t.str)
+	if s != "-0" && isJSONInteger(t.str) {
+		var err error
+		if i, parseErr := strconv.ParseInt(s, 10, 64); parseErr == nil {
+			err = t.scratch.WriteInt64(i)
+		} else if u, parseErr := strconv.ParseUint(s, 10, 64); parseErr == nil {
+			err = t.scratch.WriteUint64(u)
+		} else {
+			return t.syntaxError(fmt.Sprintf("integer %s doesn't fit in 64 bits", s))
+		}
+		if err != nil {
+			return fmt.Errorf("%w at offset %d of JSON input", err, t.iter.numRead())
+		}
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return t.syntaxError(fmt.Sprintf("number %s can't be represented as a DOUBLE", s))
+	}
+	return t.scratch.WriteFloat64(f)
+}
+
+// isJSONInteger reports whether a valid JSON number has neither a fraction
+// nor an exponent.
+func isJSONInteger(b []byte) bool {
+	for _, c := range b {
+		if c == '.' || c == 'e' || c == 'E' {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidJSONNumber reports whether b is a number according to the JSON
+// grammar.
+func isValidJSONNumber(b []byte) bool {
+	i := 0
+	digits := func() int {
+		start := i
+		for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+			i++
+		}
+		return i - start
+	}
+
+	if i < len(b) && b[i] == '-' {
+		i++
+	}
+	if i < len(b) && b[i] == '0' {
+		i++
+	} else if digits() == 0 {
+		return false
+	}
+	if i < len(b) && b[i] == '.' {
+		i++
+		if digits() == 0 {
+			return false
+		}
+	}
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return i == len(b)
+}
+
+// readString reads the rest of a string, whose opening quote was already
+// read, into t.str. Invalid UTF-8 and invalid surrogates are replaced with
+// U+FFFD in the same way as in the case of encoding/json.
+func (t *jsonTranscoder) readString() error {
+	t.str = t.str[:0]
+	iter := t.iter
+	valid := true
+	for {
+		if iter.head == iter.tail {
+			if err := iter.loadMore(); err != nil {
+				return err
+			}
+		}
+
+		// copy the bytes which don't need to be unescaped in bulk
+		chunk := iter.buf[iter.head:iter.tail]
+		n := 0
+		for n < len(chunk) && chunk[n] != '"' && chunk[n] != '\\' && chunk[n] >= 0x20 {
+			if chunk[n] >= utf8.RuneSelf {
+				valid = false
+			}
+			n++
+		}
+		t.str = append(t.str, chunk[:n]...)
+		iter.head += n
+		iter.numOfReadBytes += n
+		if n == len(chunk) {
+			continue
+		}
+
+		c, err := iter.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case '"':
+			if !valid && !utf8.Valid(t.str) {
+				t.str = replaceInvalidUTF8(t.str)
+			}
+			return nil
+		case '\\':
+			c, err := iter.ReadByte()
+			if err != nil {
+				return err
+			}
+			if err := t.readEscape(c); err != nil {
+				return err
+			}
+		default:
+			return t.syntaxError(fmt.Sprintf("invalid character %q in string literal", c))
+		}
+	}
+}
+
+// readEscape reads the rest of an escape sequence which starts with a
+// backslash followed by c.
+func (t *jsonTranscoder) readEscape(c byte) error {
+	switch c {
+	case '"', '\\', '/':
+		t.str = append(t.str, c)
+	case 'b':
+		t.str = append(t.str, '\b')
+	case 'f':
+		t.str = append(t.str, '\f')
+	case 'n':
+		t.str = append(t.str, '\n')
+	case 'r':
+		t.str = append(t.str, '\r')
+	case 't':
+		t.str = append(t.str, '\t')
+	case 'u':
+		return t.readUnicodeEscape()
+	default:
+		return t.syntaxError(fmt.Sprintf("invalid escape sequence \\%c", c))
+	}
+	return nil
+}
+
+// readUnicodeEscape reads the four hexadecimal digits of an escape sequence
+// which starts with \u and, if it encodes the first half of a surrogate pair,
+// the escape sequence encoding the second half.
+func (t *jsonTranscoder) readUnicodeEscape() error {
+	r, err := t.readHex()
+	if err != nil {
+		return err
+	}
+	for {
+		if !utf16.IsSurrogate(r) {
+			t.str = utf8.AppendRune(t.str, r)
+			return nil
+		}
+
+		if r >= 0xdc00 {
+			// the second half of a pair without the first one
+			t.str = utf8.AppendRune(t.str, unicode.ReplacementChar)
+			return nil
+		}
+
+		c, err := t.iter.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != '\\' {
+			t.iter.unreadByte()
+			t.str = utf8.AppendRune(t.str, unicode.ReplacementChar)
+			return nil
+		}
+
+		c, err = t.iter.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != 'u' {
+			t.str = utf8.AppendRune(t.str, unicode.ReplacementChar)
+			return t.readEscape(c)
+		}
+
+		second, err := t.readHex()
+		if err != nil {
+			return err
+		}
+		if decoded := utf16.DecodeRune(r, second); decoded != unicode.ReplacementChar {
+			t.str = utf8.AppendRune(t.str, decoded)
+			return nil
+		}
+
+		// the second escape sequence has to be processed on its own
+		t.str = utf8.AppendRune(t.str, unicode.ReplacementChar)
+		r = second
+	}
+}
+
+func (t *jsonTranscoder) readHex() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		c, err := t.iter.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, t.syntaxError(fmt.Sprintf("invalid character %q in \\u escape sequence", c))
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, nil
+}
+
+// replaceInvalidUTF8 replaces every byte which isn't a part of valid UTF-8
+// with U+FFFD.
+func replaceInvalidUTF8(b []byte) []byte {
+	replaced := make([]byte, 0, len(b)+8)
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			replaced = utf8.AppendRune(replaced, unicode.ReplacementChar)
+		} else {
+			replaced = append(replaced, b[i:i+size]...)
+		}
+		i += size
+	}
+	return replaced
+}
+
+func parseSSBBuffer(s string) ([]byte, bool) {
+	encoded, ok := strings.CutSuffix(s, ssbBufferSuffix)
+	if !ok {
+		return nil, false
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
@@ -5,20 +5,15 @@ import (
 	"sync"
 )
 
-var (
-	streamPool   = newSyncStreamPool()
-	iteratorPool = newSyncIteratorPool()
-)
-
 type syncStreamPool struct {
 	pool *sync.Pool
 }
 
-func newSyncStreamPool() *syncStreamPool {
+func newSyncStreamPool(cfg *frozenConfig) *syncStreamPool {
 	return &syncStreamPool{
 		pool: &sync.Pool{
 			New: func() any {
-				return newStream(nil, 512)
+				return newStream(cfg, nil, 512)
 			},
 		},
 	}
@@ -39,11 +34,11 @@ type syncIteratorPool struct {
 	pool *sync.Pool
 }
 
-func newSyncIteratorPool() *syncIteratorPool {
+func newSyncIteratorPool(cfg *frozenConfig) *syncIteratorPool {
 	return &syncIteratorPool{
 		pool: &sync.Pool{
 			New: func() any {
-				return newIterator()
+				return newIterator(cfg)
 			},
 		},
 	}
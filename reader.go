@@ -0,0 +1,381 @@
+package bipf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Kind identifies the type of a BIPF value.
+type Kind byte
+
+const (
+	KindString   = Kind(valueTypeString)
+	KindBuffer   = Kind(valueTypeBuffer)
+	KindInt      = Kind(valueTypeInt)
+	KindDouble   = Kind(valueTypeDouble)
+	KindArray    = Kind(valueTypeArray)
+	KindObject   = Kind(valueTypeObject)
+	KindBoolNull = Kind(valueTypeBoolNull)
+	KindExtended = Kind(valueTypeExtended)
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "STRING"
+	case KindBuffer:
+		return "BUFFER"
+	case KindInt:
+		return "INT"
+	case KindDouble:
+		return "DOUBLE"
+	case KindArray:
+		return "ARRAY"
+	case KindObject:
+		return "OBJECT"
+	case KindBoolNull:
+		return "BOOLNULL"
+	case KindExtended:
+		return "EXTENDED"
+	default:
+		return fmt.Sprintf("Kind(%d)", k)
+	}
+}
+
+// Reader is a pull parser which reads BIPF values one by one without using
+// reflection. It can be used to write hand-tuned decoders.
+//
+// Next reports the kind of the next value without consuming it. Scalar values
+// are consumed using the typed read methods, containers are entered using
+// Enter and left using Exit and any value can be skipped using Skip. Once all
+// values in a container were consumed Next returns io.EOF until Exit is
+// called.
+type Reader struct {
+	iter *iterator
+
+	peeked    bool
+	kind      Kind
+	length    int
+	tagOffset int
+
+	// ends stores the offsets at which the containers which were entered
+	// end, starting from the outermost one.
+	ends []uint64
+}
+
+// NewReader returns a new reader that reads BIPF values from r.
+func NewReader(r io.Reader) *Reader {
+	return ConfigDefault.NewReader(r)
+}
+
+// NewReaderBytes returns a new reader that reads BIPF values from buf.
+func NewReaderBytes(buf []byte) *Reader {
+	return ConfigDefault.NewReaderBytes(buf)
+}
+
+// Next returns the kind of the next value and the length of its payload
+// without consuming the value. If there are no more values in the current
+// container or, at the top level, in the input then Next returns io.EOF.
+func (r *Reader) Next() (Kind, int, error) {
+	if r.peeked {
+		return r.kind, r.length, nil
+	}
+
+	if len(r.ends) > 0 {
+		end := r.ends[len(r.ends)-1]
+		read := r.iter.numRead()
+		if read == end {
+			return 0, 0, io.EOF
+		}
+		if read > end {
+			return 0, 0, r.iter.annotateError(errors.New("out of bounds"))
+		}
+	} else {
+		more, err := r.iter.more()
+		if err != nil {
+			return 0, 0, err
+		}
+		if !more {
+			return 0, 0, io.EOF
+		}
+	}
+
+	tagOffset := r.iter.numOfReadBytes
+	typ, length, err := r.iter.readTag()
+	if err != nil {
+		return 0, 0, noEOF(err)
+	}
+
+	if length > math.MaxInt32 {
+		return 0, 0, r.iter.annotateError(errors.New("length too large"))
+	}
+
+	if len(r.ends) > 0 && r.iter.numRead()+length > r.ends[len(r.ends)-1] {
+		return 0, 0, r.iter.annotateError(errors.New("out of bounds"))
+	}
+
+	r.peeked = true
+	r.kind = Kind(typ)
+	r.length = int(length)
+	r.tagOffset = tagOffset
+	return r.kind, r.length, nil
+}
+
+// Offset returns the number of bytes consumed so far. If the next value was
+// already inspected using Next then the returned offset points to the start of
+// that value.
+func (r *Reader) Offset() int {
+	if r.peeked {
+		return r.tagOffset
+	}
+	return r.iter.numOfReadBytes
+}
+
+// Depth returns the number of containers which were entered but not exited.
+func (r *Reader) Depth() int {
+	return len(r.ends)
+}
+
+// ReadString consumes the next value which must be a STRING.
+func (r *Reader) ReadString() (string, error) {
+	length, err := r.consume(KindString)
+	if err != nil {
+		return "", err
+	}
+	str, err := r.iter.readStringPayload(uint64(length))
+	if err != nil {
+		return "", noEOF(err)
+	}
+	return str, nil
+}
+
+// ReadBuffer consumes the next value which must be a BUFFER.
+func (r *Reader) ReadBuffer() ([]byte, error) {
+	return r.readPayload(KindBuffer)
+}
+
+// ReadInt32 consumes the next value which must be an INT.
+func (r *Reader) ReadInt32() (int32, error) {
+	b, err := r.readFixedPayload(KindInt, 4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+// ReadFloat64 consumes the next value which must be a DOUBLE.
+func (r *Reader) ReadFloat64() (float64, error) {
+	b, err := r.readFixedPayload(KindDouble, 8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// ReadBool consumes the next value which must be a BOOLNULL set to true or
+// false.
+func (r *Reader) ReadBool() (bool, error) {
+	b, err := r.readFixedPayload(KindBoolNull, 1)
+	if err != nil {
+		return false, err
+	}
+	switch b[0] {
+	case 0x00:
+		return false, nil
+	case 0x01:
+		return true, nil
+	default:
+		return false, r.iter.annotateError(errors.New("invalid bool value"))
+	}
+}
+
+// ReadNil consumes the next value which must be a BOOLNULL set to null.
+func (r *Reader) ReadNil() error {
+	_, err := r.readFixedPayload(KindBoolNull, 0)
+	return err
+}
+
+// ReadExtended consumes the next value which must be an EXTENDED value and
+// returns its subtype and the data which follows the subtype.
+func (r *Reader) ReadExtended() (uint64, []byte, error) {
+	payload, err := r.readPayload(KindExtended)
+	if err != nil {
+		return 0, nil, err
+	}
+	subtype, data, err := parseExtendedPayload(payload)
+	if err != nil {
+		return 0, nil, r.iter.annotateError(err)
+	}
+	return subtype, data, nil
+}
+
+// ReadVal consumes the next value and stores it in the value pointed to by v.
+// See the documentation for Unmarshal for details about the conversion of BIPF
+// into a Go value. The next value can first be inspected using Next.
+func (r *Reader) ReadVal(v any) error {
+	if r.peeked {
+		if !r.unpeek() {
+			return r.readPeekedVal(v)
+		}
+	} else if len(r.ends) > 0 {
+		if r.iter.numRead() >= r.ends[len(r.ends)-1] {
+			return io.EOF
+		}
+	} else {
+		more, err := r.iter.more()
+		if err != nil {
+			return err
+		}
+		if !more {
+			return io.EOF
+		}
+	}
+	if err := r.iter.ReadVal(v); err != nil {
+		return noEOF(err)
+	}
+	if len(r.ends) > 0 && r.iter.numRead() > r.ends[len(r.ends)-1] {
+		return r.iter.annotateError(errors.New("out of bounds"))
+	}
+	return nil
+}
+
+// unpeek moves back to the start of the tag of the value inspected using Next
+// so that the value can be decoded by the iterator. It reports false if that
+// isn't possible as the tag was read before the buffer of the iterator was
+// refilled.
+func (r *Reader) unpeek() bool {
+	if !r.iter.unreadBytes(r.iter.numOfReadBytes - r.tagOffset) {
+		return false
+	}
+	r.peeked = false
+	return true
+}
+
+// readPeekedVal decodes the value inspected using Next if its tag can't be
+// unread. The payload is read and decoded together with a copy of the tag.
+func (r *Reader) readPeekedVal(v any) error {
+	kind := r.kind
+	length, err := r.consume(kind)
+	if err != nil {
+		return err
+	}
+	payload, err := r.iter.readBytes(uint64(length))
+	if err != nil {
+		return noEOF(err)
+	}
+
+	b := make([]byte, 0, binary.MaxVarintLen64+len(payload))
+	b = binary.AppendUvarint(b, uint64(length)<<3|uint64(kind))
+	b = append(b, payload...)
+
+	iter := r.iter.cfg.iteratorPool.BorrowIterator(b)
+	defer r.iter.cfg.iteratorPool.ReturnIterator(iter)
+	iter.depth = r.iter.depth
+
+	if err := iter.ReadVal(v); err != nil {
+		return addErrorOffset(noEOF(err), r.tagOffset)
+	}
+	return nil
+}
+
+// Skip consumes the next value without decoding it.
+func (r *Reader) Skip() error {
+	_, length, err := r.Next()
+	if err != nil {
+		return err
+	}
+	r.peeked = false
+	return noEOF(r.iter.skipBytes(uint64(length)))
+}
+
+// Enter consumes the tag of the next value which must be an ARRAY or an
+// OBJECT. The following calls to Next and the read methods operate on the
+// values stored in that container. The values stored in an OBJECT alternate
+// between keys and values.
+func (r *Reader) Enter() (Kind, error) {
+	kind, length, err := r.Next()
+	if err != nil {
+		return 0, err
+	}
+	if kind != KindArray && kind != KindObject {
+		return 0, fmt.Errorf("expected ARRAY or OBJECT but got %s", kind)
+	}
+	if err := r.iter.incrementDepth(); err != nil {
+		return 0, err
+	}
+	r.peeked = false
+	r.ends = append(r.ends, r.iter.numRead()+uint64(length))
+	return kind, nil
+}
+
+// Exit skips the remaining values stored in the container which was most
+// recently entered using Enter and returns to the parent container.
+func (r *Reader) Exit() error {
+	if len(r.ends) == 0 {
+		return errors.New("not in a container")
+	}
+	end := r.ends[len(r.ends)-1]
+	read := r.iter.numRead()
+	if read > end {
+		return r.iter.annotateError(errors.New("out of bounds"))
+	}
+	if err := r.iter.skipBytes(end - read); err != nil {
+		return noEOF(err)
+	}
+	if err := r.iter.decrementDepth(); err != nil {
+		return err
+	}
+	r.peeked = false
+	r.ends = r.ends[:len(r.ends)-1]
+	return nil
+}
+
+func (r *Reader) consume(expected Kind) (int, error) {
+	kind, length, err := r.Next()
+	if err != nil {
+		return 0, err
+	}
+	if kind != expected {
+		return 0, fmt.Errorf("expected %s but got %s", expected, kind)
+	}
+	r.peeked = false
+	return length, nil
+}
+
+func (r *Reader) readPayload(expected Kind) ([]byte, error) {
+	length, err := r.consume(expected)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.iter.readBytes(uint64(length))
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	return b, nil
+}
+
+func (r *Reader) readFixedPayload(expected Kind, expectedLength int) ([]byte, error) {
+	kind, length, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	if kind != expected {
+		return nil, fmt.Errorf("expected %s but got %s", expected, kind)
+	}
+	if length != expectedLength {
+		return nil, r.iter.annotateError(errors.New("invalid length"))
+	}
+	return r.readPayload(expected)
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF. It should be used when the
+// input ends in the middle of a value.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -14,6 +14,9 @@ type valDecoder interface {
 
 type valEncoder interface {
 	IsEmpty(ptr unsafe.Pointer) (bool, error)
+	// Size returns the length of the encoding of the value and records
+	// the information needed by Encode, see stream.encode.
+	Size(ptr unsafe.Pointer, stream *stream) (int, error)
 	Encode(ptr unsafe.Pointer, stream *stream) error
 }
 
@@ -22,33 +25,50 @@ func (stream *stream) WriteVal(val any) error {
 		stream.WriteNil()
 		return nil
 	}
+	encoder, err := stream.cfg.encoderOfValue(val)
+	if err != nil {
+		return err
+	}
+	return stream.encode(encoder, reflect2.PtrOf(val))
+}
+
+// sizeOfVal returns the length of the encoding of val written by WriteVal
+// without writing it.
+func (stream *stream) sizeOfVal(val any) (int, error) {
+	if nil == val {
+		return sizeOfNil, nil
+	}
+	encoder, err := stream.cfg.encoderOfValue(val)
+	if err != nil {
+		return 0, err
+	}
+	return encoder.Size(reflect2.PtrOf(val), stream)
+}
+
+func (cfg *frozenConfig) encoderOfValue(val any) (valEncoder, error) {
 	cacheKey := reflect2.RTypeOf(val)
-	encoder := encCache.getEncoderFromCache(cacheKey)
-	if encoder == nil {
-		typ := reflect2.TypeOf(val)
-		var err error
-		encoder, err = encoderOf(typ)
-		if err != nil {
-			return err
-		}
+	encoder := cfg.encoderCache.getEncoderFromCache(cacheKey)
+	if encoder != nil {
+		return encoder, nil
 	}
-	return encoder.Encode(reflect2.PtrOf(val), stream)
+	return cfg.encoderOf(reflect2.TypeOf(val))
 }
 
 type checkIsEmpty interface {
 	IsEmpty(ptr unsafe.Pointer) (bool, error)
 }
 
-func encoderOf(typ reflect2.Type) (valEncoder, error) {
+func (cfg *frozenConfig) encoderOf(typ reflect2.Type) (valEncoder, error) {
 	cacheKey := typ.RType()
-	encoder := encCache.getEncoderFromCache(cacheKey)
+	encoder := cfg.encoderCache.getEncoderFromCache(cacheKey)
 	if encoder != nil {
 		return encoder, nil
 	}
 	ctx := &ctx{
-		prefix:   "",
-		decoders: map[reflect2.Type]valDecoder{},
-		encoders: map[reflect2.Type]valEncoder{},
+		frozenConfig: cfg,
+		prefix:       "",
+		decoders:     map[reflect2.Type]valDecoder{},
+		encoders:     map[reflect2.Type]valEncoder{},
 	}
 	encoder, err := encoderOfType(ctx, typ)
 	if err != nil {
@@ -57,27 +77,28 @@ func encoderOf(typ reflect2.Type) (valEncoder, error) {
 	if typ.LikePtr() {
 		encoder = &onePtrEncoder{encoder}
 	}
-	encCache.addEncoderToCache(cacheKey, encoder)
+	cfg.encoderCache.addEncoderToCache(cacheKey, encoder)
 	return encoder, nil
 }
 
-func decoderOf(typ reflect2.Type) (valDecoder, error) {
+func (cfg *frozenConfig) decoderOf(typ reflect2.Type) (valDecoder, error) {
 	cacheKey := typ.RType()
-	decoder := decCache.getDecoderFromCache(cacheKey)
+	decoder := cfg.decoderCache.getDecoderFromCache(cacheKey)
 	if decoder != nil {
 		return decoder, nil
 	}
 	ctx := &ctx{
-		prefix:   "",
-		decoders: map[reflect2.Type]valDecoder{},
-		encoders: map[reflect2.Type]valEncoder{},
+		frozenConfig: cfg,
+		prefix:       "",
+		decoders:     map[reflect2.Type]valDecoder{},
+		encoders:     map[reflect2.Type]valEncoder{},
 	}
 	ptrType := typ.(*reflect2.UnsafePtrType)
 	decoder, err := decoderOfType(ctx, ptrType.Elem())
 	if err != nil {
 		return nil, err
 	}
-	decCache.addDecoderToCache(cacheKey, decoder)
+	cfg.decoderCache.addDecoderToCache(cacheKey, decoder)
 	return decoder, nil
 }
 
@@ -97,7 +118,23 @@ func decoderOfType(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
 }
 
 func createDecoderOfType(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
-	decoder := createDecoderOfMarshaler(typ)
+	decoder := getTypeDecoderFromExtension(ctx, typ)
+	if decoder != nil {
+		return decoder, nil
+	}
+	decoder = createDecoderOfRawMessage(ctx, typ)
+	if decoder != nil {
+		return decoder, nil
+	}
+	decoder = createDecoderOfExtended(ctx, typ)
+	if decoder != nil {
+		return decoder, nil
+	}
+	decoder = createDecoderOfNumber(ctx, typ)
+	if decoder != nil {
+		return decoder, nil
+	}
+	decoder = createDecoderOfMarshaler(ctx, typ)
 	if decoder != nil {
 		return decoder, nil
 	}
@@ -123,10 +160,11 @@ func createDecoderOfType(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
 	case reflect.Ptr:
 		return decoderOfOptional(ctx, typ)
 	}
-	return nil, errors.New("decoder not found")
+	return nil, &UnsupportedTypeError{Type: typ.Type1()}
 }
 
 type ctx struct {
+	*frozenConfig
 	prefix   string
 	encoders map[reflect2.Type]valEncoder
 	decoders map[reflect2.Type]valDecoder
@@ -134,9 +172,10 @@ type ctx struct {
 
 func (b *ctx) append(prefix string) *ctx {
 	return &ctx{
-		prefix:   b.prefix + " " + prefix,
-		encoders: b.encoders,
-		decoders: b.decoders,
+		frozenConfig: b.frozenConfig,
+		prefix:       b.prefix + " " + prefix,
+		encoders:     b.encoders,
+		decoders:     b.decoders,
 	}
 }
 
@@ -156,7 +195,23 @@ func encoderOfType(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 }
 
 func createEncoderOfType(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
-	encoder, err := createEncoderOfMarshaler(ctx, typ)
+	if encoder := getTypeEncoderFromExtension(ctx, typ); encoder != nil {
+		return encoder, nil
+	}
+	if encoder := createEncoderOfRawMessage(ctx, typ); encoder != nil {
+		return encoder, nil
+	}
+	if encoder := createEncoderOfNumber(ctx, typ); encoder != nil {
+		return encoder, nil
+	}
+	encoder, err := createEncoderOfExtended(ctx, typ)
+	if err != nil {
+		return nil, err
+	}
+	if encoder != nil {
+		return encoder, nil
+	}
+	encoder, err = createEncoderOfMarshaler(ctx, typ)
 	if err == nil {
 		return encoder, nil
 	}
@@ -179,7 +234,7 @@ func createEncoderOfType(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 	case reflect.Ptr:
 		return encoderOfOptional(ctx, typ)
 	}
-	return nil, errors.New("encoder not found")
+	return nil, &UnsupportedTypeError{Type: typ.Type1()}
 }
 
 type placeholderDecoder struct {
@@ -194,6 +249,10 @@ type placeholderEncoder struct {
 	encoder valEncoder
 }
 
+func (encoder *placeholderEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return encoder.encoder.Size(ptr, stream)
+}
+
 func (encoder *placeholderEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return encoder.encoder.Encode(ptr, stream)
 }
@@ -210,6 +269,10 @@ func (encoder *onePtrEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
 	return encoder.encoder.IsEmpty(unsafe.Pointer(&ptr))
 }
 
+func (encoder *onePtrEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return encoder.encoder.Size(unsafe.Pointer(&ptr), stream)
+}
+
 func (encoder *onePtrEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return encoder.encoder.Encode(unsafe.Pointer(&ptr), stream)
 }
@@ -217,14 +280,14 @@ func (encoder *onePtrEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 func (iter *iterator) ReadVal(obj any) error {
 	depth := iter.depth
 	cacheKey := reflect2.RTypeOf(obj)
-	decoder := decCache.getDecoderFromCache(cacheKey)
+	decoder := iter.cfg.decoderCache.getDecoderFromCache(cacheKey)
 	if decoder == nil {
 		typ := reflect2.TypeOf(obj)
 		if typ == nil || typ.Kind() != reflect.Ptr {
 			return errors.New("can only unmarshal into pointer")
 		}
 		var err error
-		decoder, err = decoderOf(typ)
+		decoder, err = iter.cfg.decoderOf(typ)
 		if err != nil {
 			return err
 		}
@@ -2,7 +2,6 @@ package bipf
 
 import (
 	"errors"
-	"fmt"
 	"unsafe"
 
 	"github.com/modern-go/reflect2"
@@ -31,6 +30,10 @@ func encoderOfArray(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 
 type emptyArrayEncoder struct{}
 
+func (encoder emptyArrayEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfContainer(0), nil
+}
+
 func (encoder emptyArrayEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	stream.WriteEmptyArray()
 	return nil
@@ -45,24 +48,35 @@ type arrayEncoder struct {
 	elemEncoder valEncoder
 }
 
+func (encoder *arrayEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	i := stream.reserveSize()
+	length := 0
+	for j := 0; j < encoder.arrayType.Len(); j++ {
+		elemPtr := encoder.arrayType.UnsafeGetIndex(ptr, j)
+		n, err := encoder.elemEncoder.Size(elemPtr, stream)
+		if err != nil {
+			return 0, wrapf(err, "type '%v'", encoder.arrayType)
+		}
+		length += n
+	}
+	stream.sizes[i].length = length
+	return sizeOfContainer(length), nil
+}
+
 func (encoder *arrayEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
-	tmpStream := streamPool.BorrowStream(nil)
-	defer streamPool.ReturnStream(tmpStream)
+	length := stream.takeSize().length
+	stream.WriteTag(uint64(length), valueTypeArray)
+	start := stream.Buffered()
 
 	for i := 0; i < encoder.arrayType.Len(); i++ {
 		elemPtr := encoder.arrayType.UnsafeGetIndex(ptr, i)
-		err := encoder.elemEncoder.Encode(elemPtr, tmpStream)
+		err := encoder.elemEncoder.Encode(elemPtr, stream)
 		if err != nil {
 			return wrapf(err, "type '%v'", encoder.arrayType)
 		}
 	}
 
-	stream.WriteTag(uint64(tmpStream.Buffered()), valueTypeArray)
-	if _, err := stream.Write(tmpStream.Buffer()); err != nil {
-		return err
-	}
-
-	return nil
+	return stream.checkWritten(start, length)
 }
 
 func (encoder *arrayEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -75,9 +89,10 @@ type arrayDecoder struct {
 }
 
 func (decoder *arrayDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	offset := iter.numRead()
 	typ, l, err := iter.readTag()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	arrayType := decoder.arrayType
@@ -87,7 +102,7 @@ func (decoder *arrayDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	}
 
 	if typ != valueTypeArray {
-		return fmt.Errorf("incorrect type: %v", typ)
+		return newUnmarshalTypeError(typ, arrayType.Type1(), offset)
 	}
 
 	start := iter.numRead()
@@ -95,16 +110,16 @@ func (decoder *arrayDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 
 	for iter.numRead()-start < l {
 		if i >= arrayType.Len() {
-			return errors.New("provided array is too short")
+			return newUnmarshalTypeError(typ, arrayType.Type1(), offset)
 		}
 		elemPtr := arrayType.UnsafeGetIndex(ptr, i)
 		err := decoder.elemDecoder.Decode(elemPtr, iter)
 		if err != nil {
-			return err
+			return addErrorIndex(err, i)
 		}
 
 		if iter.numRead()-start > l {
-			return errors.New("out of bounds")
+			return iter.annotateError(errors.New("out of bounds"))
 		}
 
 		i++
@@ -12,9 +12,26 @@ type dynamicEncoder struct {
 	valType reflect2.Type
 }
 
+func (encoder *dynamicEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	obj := encoder.valType.UnsafeIndirect(ptr)
+	if obj == nil {
+		return sizeOfNil, nil
+	}
+	enc, err := stream.cfg.encoderOfValue(obj)
+	if err != nil {
+		return 0, err
+	}
+	stream.recordEncoder(enc)
+	return enc.Size(reflect2.PtrOf(obj), stream)
+}
+
 func (encoder *dynamicEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	obj := encoder.valType.UnsafeIndirect(ptr)
-	return stream.WriteVal(obj)
+	if obj == nil {
+		stream.WriteNil()
+		return nil
+	}
+	return stream.takeEncoder().Encode(reflect2.PtrOf(obj), stream)
 }
 
 func (encoder *dynamicEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -52,12 +69,10 @@ func (decoder *efaceDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	}
 	if nilIsNext {
 		if ptrElemType.Kind() != reflect.Ptr {
-			if err := iter.ReadNil(); err != nil {
-				return err
-			}
 			*pObj = nil
 			return nil
 		}
+		iter.unreadByte()
 	}
 	if reflect2.IsNil(obj) {
 		obj := ptrElemType.New()
@@ -81,9 +96,6 @@ func (decoder *ifaceDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 		return err
 	}
 	if nilIsNext {
-		if err := iter.ReadNil(); err != nil {
-			return err
-		}
 		decoder.valType.UnsafeSet(ptr, decoder.valType.UnsafeNew())
 		return nil
 	}
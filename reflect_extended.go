@@ -0,0 +1,230 @@
+package bipf
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// Extended is an EXTENDED value of a subtype which wasn't registered using
+// API.RegisterExtendedType. Unmarshal stores EXTENDED values of unknown
+// subtypes decoded into an interface value as Extended so that they can be
+// encoded again without changes.
+type Extended struct {
+	// Type is the subtype of the value.
+	Type uint64
+
+	// Data is the payload of the value which follows the subtype.
+	Data []byte
+}
+
+var extendedGoType = reflect2.TypeOfPtr((*Extended)(nil)).Elem()
+
+var int64GoType = reflect2.TypeOfPtr((*int64)(nil)).Elem()
+var uint64GoType = reflect2.TypeOfPtr((*uint64)(nil)).Elem()
+
+// ExtendedType describes a Go type which is encoded as an EXTENDED value of a
+// specific subtype. It is created using NewExtendedType and registered using
+// API.RegisterExtendedType.
+type ExtendedType struct {
+	subtype   uint64
+	typ       reflect2.Type
+	encode    func(ptr unsafe.Pointer) ([]byte, error)
+	decode    func(ptr unsafe.Pointer, data []byte) error
+	decodeAny func(data []byte) (any, error)
+}
+
+// NewExtendedType describes a Go type which is encoded as an EXTENDED value
+// of the given subtype. The payload of the value consists of the subtype
+// encoded as an unsigned varint followed by the data returned by encode. When
+// such a value is decoded decode is called with that data.
+//
+// NewExtendedType panics if T is Extended, int64 or uint64.
+func NewExtendedType[T any](subtype uint64, encode func(T) ([]byte, error), decode func([]byte) (T, error)) *ExtendedType {
+	typ := reflect2.TypeOfPtr((*T)(nil)).Elem()
+	if typ == extendedGoType {
+		panic("bipf: Extended can't be registered")
+	}
+	if typ == int64GoType || typ == uint64GoType {
+		panic(fmt.Sprintf("bipf: %v can't be registered, see IntegerPolicy", typ))
+	}
+	return &ExtendedType{
+		subtype: subtype,
+		typ:     typ,
+		encode: func(ptr unsafe.Pointer) ([]byte, error) {
+			return encode(*(*T)(ptr))
+		},
+		decode: func(ptr unsafe.Pointer, data []byte) error {
+			v, err := decode(data)
+			if err != nil {
+				return err
+			}
+			*(*T)(ptr) = v
+			return nil
+		},
+		decodeAny: func(data []byte) (any, error) {
+			return decode(data)
+		},
+	}
+}
+
+// RegisterExtendedType registers a Go type which is encoded as an EXTENDED
+// value of the given subtype in ConfigDefault, which is used by the
+// package-level functions such as Marshal and Unmarshal. It is a shorthand for
+// ConfigDefault.RegisterExtendedType(NewExtendedType(subtype, encode, decode)).
+// Other APIs don't use this registration, see API.RegisterExtendedType.
+//
+// RegisterExtendedType panics if the type or the subtype was already
+// registered. It should be called during initialization, for example in an
+// init function, before ConfigDefault is used.
+func RegisterExtendedType[T any](subtype uint64, encode func(T) ([]byte, error), decode func([]byte) (T, error)) {
+	ConfigDefault.RegisterExtendedType(NewExtendedType(subtype, encode, decode))
+}
+
+// extendedTypeRegistry holds the extended types registered in an API. It is
+// shared by the API and the APIs derived from it.
+type extendedTypeRegistry struct {
+	lock     sync.RWMutex
+	types    map[uintptr]*ExtendedType
+	subtypes map[uint64]*ExtendedType
+}
+
+func (r *extendedTypeRegistry) register(t *ExtendedType) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if existing, ok := r.types[t.typ.RType()]; ok {
+		panic(fmt.Sprintf("bipf: type %v already registered with subtype %d", t.typ, existing.subtype))
+	}
+	if existing, ok := r.subtypes[t.subtype]; ok {
+		panic(fmt.Sprintf("bipf: subtype %d already registered for type %v", t.subtype, existing.typ))
+	}
+	if r.types == nil {
+		r.types = make(map[uintptr]*ExtendedType)
+		r.subtypes = make(map[uint64]*ExtendedType)
+	}
+	r.types[t.typ.RType()] = t
+	r.subtypes[t.subtype] = t
+}
+
+func (r *extendedTypeRegistry) byType(typ reflect2.Type) (*ExtendedType, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	t, ok := r.types[typ.RType()]
+	return t, ok
+}
+
+func (r *extendedTypeRegistry) bySubtype(subtype uint64) (*ExtendedType, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	t, ok := r.subtypes[subtype]
+	return t, ok
+}
+
+func createEncoderOfExtended(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
+	if typ == extendedGoType {
+		return &extendedCodec{}, nil
+	}
+	if t, ok := ctx.extendedTypes.byType(typ); ok {
+		checkIsEmpty, err := createCheckIsEmpty(ctx, typ)
+		if err != nil {
+			return nil, err
+		}
+		return &registeredExtendedCodec{t, checkIsEmpty}, nil
+	}
+	return nil, nil
+}
+
+func createDecoderOfExtended(ctx *ctx, typ reflect2.Type) valDecoder {
+	if typ == extendedGoType {
+		return &extendedCodec{}
+	}
+	if t, ok := ctx.extendedTypes.byType(typ); ok {
+		return &registeredExtendedCodec{extendedType: t}
+	}
+	return nil
+}
+
+type extendedCodec struct {
+}
+
+func (codec *extendedCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	subtype, data, err := iter.ReadExtended()
+	if err != nil {
+		return err
+	}
+	*((*Extended)(ptr)) = Extended{Type: subtype, Data: data}
+	return nil
+}
+
+func (codec *extendedCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	v := (*Extended)(ptr)
+	return sizeOfExtended(v.Type, len(v.Data)), nil
+}
+
+func (codec *extendedCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
+	v := (*Extended)(ptr)
+	return stream.WriteExtended(v.Type, v.Data)
+}
+
+func (codec *extendedCodec) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return false, nil
+}
+
+type registeredExtendedCodec struct {
+	extendedType *ExtendedType
+	checkIsEmpty checkIsEmpty
+}
+
+func (codec *registeredExtendedCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	offset := iter.numRead()
+	subtype, data, err := iter.readExtended(codec.extendedType.typ.Type1())
+	if err != nil {
+		return err
+	}
+	if subtype != codec.extendedType.subtype {
+		return newUnmarshalTypeError(valueTypeExtended, codec.extendedType.typ.Type1(), offset)
+	}
+	return codec.extendedType.decode(ptr, data)
+}
+
+func (codec *registeredExtendedCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	data, err := codec.extendedType.encode(ptr)
+	if err != nil {
+		return 0, err
+	}
+	stream.recordBytes(data)
+	return sizeOfExtended(codec.extendedType.subtype, len(data)), nil
+}
+
+func (codec *registeredExtendedCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
+	return stream.WriteExtended(codec.extendedType.subtype, stream.takeBytes())
+}
+
+func (codec *registeredExtendedCodec) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return codec.checkIsEmpty.IsEmpty(ptr)
+}
+
+// readExtendedAny reads an EXTENDED value and returns an integer, the value of
+// the registered type or Extended if its subtype wasn't registered.
+func (iter *iterator) readExtendedAny() (any, error) {
+	subtype, data, err := iter.ReadExtended()
+	if err != nil {
+		return nil, err
+	}
+	if isInteger, signed := iter.cfg.extendedInteger(subtype); isInteger {
+		v, err := parseExtendedInteger(data)
+		if err != nil {
+			return nil, iter.annotateError(err)
+		}
+		if signed {
+			return int64(v), nil
+		}
+		return v, nil
+	}
+	if t, ok := iter.cfg.extendedTypes.bySubtype(subtype); ok {
+		return t.decodeAny(data)
+	}
+	return Extended{Type: subtype, Data: data}, nil
+}
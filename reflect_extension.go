@@ -1,40 +1,333 @@
 package bipf
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
 	"strings"
 	"unicode"
+	"unsafe"
 
 	"github.com/modern-go/reflect2"
 )
 
-var fieldDecoders = map[string]valDecoder{}
-var fieldEncoders = map[string]valEncoder{}
+var typeDecoders = map[string]ValDecoder{}
+var fieldDecoders = map[string]ValDecoder{}
+var typeEncoders = map[string]ValEncoder{}
+var fieldEncoders = map[string]ValEncoder{}
+var extensions = []Extension{}
 
-type structDescriptor struct {
+// ValEncoder encodes values of a single type. The pointer passed to its
+// methods points to a value of that type.
+type ValEncoder interface {
+	// IsEmpty reports whether the value should be omitted when it is
+	// stored in a struct field tagged with omitempty.
+	IsEmpty(ptr unsafe.Pointer) (bool, error)
+
+	// Encode must write exactly one well-formed value, otherwise an
+	// error is returned by the function which encodes it.
+	Encode(ptr unsafe.Pointer, w *Writer) error
+}
+
+// ValDecoder decodes values of a single type. The pointer passed to Decode
+// points to a value of that type.
+type ValDecoder interface {
+	Decode(ptr unsafe.Pointer, r *Reader) error
+}
+
+// EncoderFunc is a function which can be used as a ValEncoder, see
+// RegisterTypeEncoderFunc.
+type EncoderFunc func(ptr unsafe.Pointer, w *Writer) error
+
+// DecoderFunc is a function which can be used as a ValDecoder, see
+// RegisterTypeDecoderFunc.
+type DecoderFunc func(ptr unsafe.Pointer, r *Reader) error
+
+// StructDescriptor describes how a struct is encoded and decoded. It can be
+// modified by an Extension.
+type StructDescriptor struct {
 	Type   reflect2.Type
-	Fields []*binding
+	Fields []*Binding
 }
 
-// binding describe how should we encode/decode the struct field
-type binding struct {
+// GetField returns the binding of the field with the given name or nil if
+// there is no such field.
+func (structDescriptor *StructDescriptor) GetField(fieldName string) *Binding {
+	for _, binding := range structDescriptor.Fields {
+		if binding.Field.Name() == fieldName {
+			return binding
+		}
+	}
+	return nil
+}
+
+// Binding describes how a struct field is encoded and decoded. FromNames are
+// the OBJECT keys which are decoded into the field and ToNames are the OBJECT
+// keys under which the field is encoded.
+type Binding struct {
 	levels    []int
 	Field     reflect2.StructField
 	FromNames []string
 	ToNames   []string
-	Encoder   valEncoder
-	Decoder   valDecoder
+	Encoder   ValEncoder
+	Decoder   ValDecoder
+
+	fieldEncoder *structFieldEncoder
+	fieldDecoder *structFieldDecoder
+}
+
+// Extension customizes how encoders and decoders are created. CreateEncoder
+// and CreateDecoder can return nil to fall back to the default behaviour.
+type Extension interface {
+	UpdateStructDescriptor(structDescriptor *StructDescriptor)
+	CreateEncoder(typ reflect2.Type) ValEncoder
+	CreateDecoder(typ reflect2.Type) ValDecoder
+}
+
+// DummyExtension is an Extension which does nothing. It can be embedded to
+// implement only some of the methods of Extension.
+type DummyExtension struct {
+}
+
+// UpdateStructDescriptor does nothing.
+func (extension *DummyExtension) UpdateStructDescriptor(structDescriptor *StructDescriptor) {
+}
+
+// CreateEncoder returns nil.
+func (extension *DummyExtension) CreateEncoder(typ reflect2.Type) ValEncoder {
+	return nil
+}
+
+// CreateDecoder returns nil.
+func (extension *DummyExtension) CreateDecoder(typ reflect2.Type) ValDecoder {
+	return nil
+}
+
+type funcEncoder struct {
+	fun         EncoderFunc
+	isEmptyFunc func(ptr unsafe.Pointer) bool
+}
+
+func (encoder *funcEncoder) Encode(ptr unsafe.Pointer, w *Writer) error {
+	return encoder.fun(ptr, w)
 }
 
-func describeStruct(ctx *ctx, typ reflect2.Type) (*structDescriptor, error) {
+func (encoder *funcEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	if encoder.isEmptyFunc == nil {
+		return false, nil
+	}
+	return encoder.isEmptyFunc(ptr), nil
+}
+
+type funcDecoder struct {
+	fun DecoderFunc
+}
+
+func (decoder *funcDecoder) Decode(ptr unsafe.Pointer, r *Reader) error {
+	return decoder.fun(ptr, r)
+}
+
+// RegisterTypeDecoderFunc registers a function used to decode values of the
+// type with the given name, as returned by reflect.Type.String, for example
+// "time.Time".
+func RegisterTypeDecoderFunc(typ string, fun DecoderFunc) {
+	typeDecoders[typ] = &funcDecoder{fun}
+}
+
+// RegisterTypeDecoder registers a decoder used to decode values of the type
+// with the given name, as returned by reflect.Type.String.
+func RegisterTypeDecoder(typ string, decoder ValDecoder) {
+	typeDecoders[typ] = decoder
+}
+
+// RegisterFieldDecoderFunc registers a function used to decode the given field
+// of the struct type with the given name.
+func RegisterFieldDecoderFunc(typ string, field string, fun DecoderFunc) {
+	RegisterFieldDecoder(typ, field, &funcDecoder{fun})
+}
+
+// RegisterFieldDecoder registers a decoder used to decode the given field of
+// the struct type with the given name.
+func RegisterFieldDecoder(typ string, field string, decoder ValDecoder) {
+	fieldDecoders[fmt.Sprintf("%s/%s", typ, field)] = decoder
+}
+
+// RegisterTypeEncoderFunc registers a function used to encode values of the
+// type with the given name, as returned by reflect.Type.String, for example
+// "time.Time". If isEmptyFunc is nil then the values are never considered
+// empty.
+func RegisterTypeEncoderFunc(typ string, fun EncoderFunc, isEmptyFunc func(ptr unsafe.Pointer) bool) {
+	typeEncoders[typ] = &funcEncoder{fun, isEmptyFunc}
+}
+
+// RegisterTypeEncoder registers an encoder used to encode values of the type
+// with the given name, as returned by reflect.Type.String.
+func RegisterTypeEncoder(typ string, encoder ValEncoder) {
+	typeEncoders[typ] = encoder
+}
+
+// RegisterFieldEncoderFunc registers a function used to encode the given field
+// of the struct type with the given name. If isEmptyFunc is nil then the field
+// is never considered empty.
+func RegisterFieldEncoderFunc(typ string, field string, fun EncoderFunc, isEmptyFunc func(ptr unsafe.Pointer) bool) {
+	RegisterFieldEncoder(typ, field, &funcEncoder{fun, isEmptyFunc})
+}
+
+// RegisterFieldEncoder registers an encoder used to encode the given field of
+// the struct type with the given name.
+func RegisterFieldEncoder(typ string, field string, encoder ValEncoder) {
+	fieldEncoders[fmt.Sprintf("%s/%s", typ, field)] = encoder
+}
+
+// RegisterExtension registers an extension which is used by all APIs.
+//
+// The registration functions aren't safe to call concurrently with encoding
+// or decoding and don't affect types which were already encoded or decoded,
+// therefore they should be called during initialization, for example in an
+// init function.
+func RegisterExtension(extension Extension) {
+	extensions = append(extensions, extension)
+}
+
+func getTypeDecoderFromExtension(ctx *ctx, typ reflect2.Type) valDecoder {
+	for _, extension := range extensions {
+		if decoder := extension.CreateDecoder(typ); decoder != nil {
+			return toInternalDecoder(decoder)
+		}
+	}
+	for _, extension := range ctx.getExtensions() {
+		if decoder := extension.CreateDecoder(typ); decoder != nil {
+			return toInternalDecoder(decoder)
+		}
+	}
+	if decoder := typeDecoders[typ.String()]; decoder != nil {
+		return toInternalDecoder(decoder)
+	}
+	if typ.Kind() == reflect.Ptr {
+		ptrType := typ.(*reflect2.UnsafePtrType)
+		if decoder := typeDecoders[ptrType.Elem().String()]; decoder != nil {
+			return &optionalDecoder{ptrType.Elem(), toInternalDecoder(decoder)}
+		}
+	}
+	return nil
+}
+
+func getTypeEncoderFromExtension(ctx *ctx, typ reflect2.Type) valEncoder {
+	for _, extension := range extensions {
+		if encoder := extension.CreateEncoder(typ); encoder != nil {
+			return toInternalEncoder(encoder)
+		}
+	}
+	for _, extension := range ctx.getExtensions() {
+		if encoder := extension.CreateEncoder(typ); encoder != nil {
+			return toInternalEncoder(encoder)
+		}
+	}
+	if encoder := typeEncoders[typ.String()]; encoder != nil {
+		return toInternalEncoder(encoder)
+	}
+	if typ.Kind() == reflect.Ptr {
+		ptrType := typ.(*reflect2.UnsafePtrType)
+		if encoder := typeEncoders[ptrType.Elem().String()]; encoder != nil {
+			return &optionalEncoder{toInternalEncoder(encoder)}
+		}
+	}
+	return nil
+}
+
+// exportedEncoder exposes an internal encoder as a ValEncoder.
+type exportedEncoder struct {
+	encoder valEncoder
+}
+
+func (encoder *exportedEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return encoder.encoder.IsEmpty(ptr)
+}
+
+func (encoder *exportedEncoder) Encode(ptr unsafe.Pointer, w *Writer) error {
+	return w.stream.encode(encoder.encoder, ptr)
+}
+
+// extensionEncoder adapts a ValEncoder to the internal encoder interface.
+type extensionEncoder struct {
+	encoder ValEncoder
+}
+
+func (encoder *extensionEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return encoder.encoder.IsEmpty(ptr)
+}
+
+// Size calls the ValEncoder and records its output, with the tags of the
+// containers inserted, as its size can't be known otherwise. The output is
+// checked as writing no values or multiple values would corrupt the container
+// in which the value is stored.
+func (encoder *extensionEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	tmpStream := stream.cfg.streamPool.BorrowStream(nil)
+	defer stream.cfg.streamPool.ReturnStream(tmpStream)
+
+	w := &Writer{stream: tmpStream}
+	if err := encoder.encoder.Encode(ptr, w); err != nil {
+		return 0, err
+	}
+
+	offset := len(stream.recorded)
+	stream.recorded = w.tags.appendTo(stream.recorded, tmpStream.Buffer())
+	output := stream.recorded[offset:]
+	if err := checkValue(output, stream.cfg.maxDepth); err != nil {
+		return 0, wrap(err, "custom encoder must write exactly one well-formed value")
+	}
+	stream.recordAppended(offset)
+	return len(output), nil
+}
+
+func (encoder *extensionEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+	_, err := stream.Write(stream.takeBytes())
+	return err
+}
+
+func toInternalEncoder(encoder ValEncoder) valEncoder {
+	if exported, ok := encoder.(*exportedEncoder); ok {
+		return exported.encoder
+	}
+	return &extensionEncoder{encoder}
+}
+
+// exportedDecoder exposes an internal decoder as a ValDecoder.
+type exportedDecoder struct {
+	decoder valDecoder
+}
+
+func (decoder *exportedDecoder) Decode(ptr unsafe.Pointer, r *Reader) error {
+	if r.peeked {
+		return errors.New("Decode called after Next")
+	}
+	return decoder.decoder.Decode(ptr, r.iter)
+}
+
+// extensionDecoder adapts a ValDecoder to the internal decoder interface.
+type extensionDecoder struct {
+	decoder ValDecoder
+}
+
+func (decoder *extensionDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	return decoder.decoder.Decode(ptr, &Reader{iter: iter})
+}
+
+func toInternalDecoder(decoder ValDecoder) valDecoder {
+	if exported, ok := decoder.(*exportedDecoder); ok {
+		return exported.decoder
+	}
+	return &extensionDecoder{decoder}
+}
+
+func describeStruct(ctx *ctx, typ reflect2.Type) (*StructDescriptor, error) {
 	structType := typ.(*reflect2.UnsafeStructType)
-	embeddedBindings := []*binding{}
-	bindings := []*binding{}
+	embeddedBindings := []*Binding{}
+	bindings := []*Binding{}
 	for i := 0; i < structType.NumField(); i++ {
 		field := structType.Field(i)
-		tag, hastag := field.Tag().Lookup(tagKey)
+		tag, hastag := field.Tag().Lookup(ctx.tagKey)
 		if hastag && (tag == "-" || field.Name() == "_") {
 			continue
 		}
@@ -47,9 +340,9 @@ func describeStruct(ctx *ctx, typ reflect2.Type) (*structDescriptor, error) {
 				}
 				for _, binding := range structDescriptor.Fields {
 					binding.levels = append([]int{i}, binding.levels...)
-					omitempty := binding.Encoder.(*structFieldEncoder).omitempty
-					binding.Encoder = &structFieldEncoder{field, binding.Encoder, omitempty}
-					binding.Decoder = &structFieldDecoder{field, binding.Decoder}
+					omitempty := binding.fieldEncoder.omitempty
+					binding.fieldEncoder = &structFieldEncoder{field, binding.fieldEncoder, omitempty}
+					binding.fieldDecoder = &structFieldDecoder{field, binding.fieldDecoder}
 					embeddedBindings = append(embeddedBindings, binding)
 				}
 				continue
@@ -62,11 +355,9 @@ func describeStruct(ctx *ctx, typ reflect2.Type) (*structDescriptor, error) {
 					}
 					for _, binding := range structDescriptor.Fields {
 						binding.levels = append([]int{i}, binding.levels...)
-						omitempty := binding.Encoder.(*structFieldEncoder).omitempty
-						binding.Encoder = &dereferenceEncoder{binding.Encoder}
-						binding.Encoder = &structFieldEncoder{field, binding.Encoder, omitempty}
-						binding.Decoder = &dereferenceDecoder{ptrType.Elem(), binding.Decoder}
-						binding.Decoder = &structFieldDecoder{field, binding.Decoder}
+						omitempty := binding.fieldEncoder.omitempty
+						binding.fieldEncoder = &structFieldEncoder{field, &dereferenceEncoder{binding.fieldEncoder}, omitempty}
+						binding.fieldDecoder = &structFieldDecoder{field, &dereferenceDecoder{ptrType.Elem(), binding.fieldDecoder}}
 						embeddedBindings = append(embeddedBindings, binding)
 					}
 					continue
@@ -77,21 +368,21 @@ func describeStruct(ctx *ctx, typ reflect2.Type) (*structDescriptor, error) {
 		fieldCacheKey := fmt.Sprintf("%s/%s", typ.String(), field.Name())
 		decoder := fieldDecoders[fieldCacheKey]
 		if decoder == nil {
-			var err error
-			decoder, err = decoderOfType(ctx.append(field.Name()), field.Type())
+			fieldDecoder, err := decoderOfType(ctx.append(field.Name()), field.Type())
 			if err != nil {
 				return nil, err
 			}
+			decoder = &exportedDecoder{fieldDecoder}
 		}
 		encoder := fieldEncoders[fieldCacheKey]
 		if encoder == nil {
-			var err error
-			encoder, err = encoderOfType(ctx.append(field.Name()), field.Type())
+			fieldEncoder, err := encoderOfType(ctx.append(field.Name()), field.Type())
 			if err != nil {
 				return nil, err
 			}
+			encoder = &exportedEncoder{fieldEncoder}
 		}
-		binding := &binding{
+		binding := &Binding{
 			Field:     field,
 			FromNames: fieldNames,
 			ToNames:   fieldNames,
@@ -101,14 +392,20 @@ func describeStruct(ctx *ctx, typ reflect2.Type) (*structDescriptor, error) {
 		binding.levels = []int{i}
 		bindings = append(bindings, binding)
 	}
-	return createStructDescriptor(typ, bindings, embeddedBindings), nil
+	return createStructDescriptor(ctx, typ, bindings, embeddedBindings), nil
 }
-func createStructDescriptor(typ reflect2.Type, bindings []*binding, embeddedBindings []*binding) *structDescriptor {
-	structDescriptor := &structDescriptor{
+func createStructDescriptor(ctx *ctx, typ reflect2.Type, bindings []*Binding, embeddedBindings []*Binding) *StructDescriptor {
+	structDescriptor := &StructDescriptor{
 		Type:   typ,
 		Fields: bindings,
 	}
-	processTags(structDescriptor)
+	for _, extension := range extensions {
+		extension.UpdateStructDescriptor(structDescriptor)
+	}
+	for _, extension := range ctx.getExtensions() {
+		extension.UpdateStructDescriptor(structDescriptor)
+	}
+	processTags(ctx, structDescriptor)
 	// merge normal & embedded bindings & sort with original order
 	allBindings := sortableBindings(append(embeddedBindings, structDescriptor.Fields...))
 	sort.Sort(allBindings)
@@ -116,7 +413,7 @@ func createStructDescriptor(typ reflect2.Type, bindings []*binding, embeddedBind
 	return structDescriptor
 }
 
-type sortableBindings []*binding
+type sortableBindings []*Binding
 
 func (bindings sortableBindings) Len() int {
 	return len(bindings)
@@ -140,17 +437,17 @@ func (bindings sortableBindings) Swap(i, j int) {
 	bindings[i], bindings[j] = bindings[j], bindings[i]
 }
 
-func processTags(structDescriptor *structDescriptor) {
+func processTags(ctx *ctx, structDescriptor *StructDescriptor) {
 	for _, binding := range structDescriptor.Fields {
 		shouldOmitEmpty := false
-		tagParts := strings.Split(binding.Field.Tag().Get(tagKey), ",")
+		tagParts := strings.Split(binding.Field.Tag().Get(ctx.tagKey), ",")
 		for _, tagPart := range tagParts[1:] {
 			if tagPart == "omitempty" {
 				shouldOmitEmpty = true
 			}
 		}
-		binding.Decoder = &structFieldDecoder{binding.Field, binding.Decoder}
-		binding.Encoder = &structFieldEncoder{binding.Field, binding.Encoder, shouldOmitEmpty}
+		binding.fieldDecoder = &structFieldDecoder{binding.Field, toInternalDecoder(binding.Decoder)}
+		binding.fieldEncoder = &structFieldEncoder{binding.Field, toInternalEncoder(binding.Encoder), shouldOmitEmpty}
 	}
 }
 
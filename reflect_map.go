@@ -1,9 +1,11 @@
 package bipf
 
 import (
+	"bytes"
 	"errors"
-	"io"
+	"fmt"
 	"reflect"
+	"sort"
 	"unsafe"
 
 	"github.com/modern-go/reflect2"
@@ -38,6 +40,13 @@ func encoderOfMap(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 	if err != nil {
 		return nil, err
 	}
+	if ctx.sortMapKeys {
+		return &sortKeysMapEncoder{
+			mapType:     mapType,
+			keyEncoder:  keyEncoder,
+			elemEncoder: elemEncoder,
+		}, nil
+	}
 	return &mapEncoder{
 		mapType:     mapType,
 		keyEncoder:  keyEncoder,
@@ -83,14 +92,14 @@ func decoderOfMapKey(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
 		reflect.Uintptr:
 		return decoderOfType(ctx, reflect2.DefaultTypeOfKind(typ.Kind()))
 	default:
-		return nil, errors.New("decoder of map key not found")
+		return nil, &UnsupportedTypeError{Type: typ.Type1()}
 	}
 }
 
 func encoderOfMapKey(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 	if typ.Kind() != reflect.String {
 		if typ == binaryMarshalerType {
-			enc, err := encoderOf(reflect2.TypeOf([]byte{}))
+			enc, err := ctx.encoderOf(reflect2.TypeOf([]byte{}))
 			if err != nil {
 				return nil, err
 			}
@@ -99,7 +108,7 @@ func encoderOfMapKey(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 			}, nil
 		}
 		if typ.Implements(binaryMarshalerType) {
-			enc, err := encoderOf(reflect2.TypeOf([]byte{}))
+			enc, err := ctx.encoderOf(reflect2.TypeOf([]byte{}))
 			if err != nil {
 				return nil, err
 			}
@@ -125,7 +134,7 @@ func encoderOfMapKey(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		if typ.Kind() == reflect.Interface {
 			return &dynamicMapKeyEncoder{ctx, typ}, nil
 		}
-		return nil, errors.New("encoder of map key not found")
+		return nil, &UnsupportedTypeError{Type: typ.Type1()}
 	}
 }
 
@@ -148,13 +157,14 @@ func (decoder *mapDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 		return nil
 	}
 
+	offset := iter.numRead()
 	typ, l, err := iter.readTag()
 	if err != nil {
 		return err
 	}
 
 	if typ != valueTypeObject {
-		return errors.New("invalid type")
+		return newUnmarshalTypeError(typ, mapType.Type1(), offset)
 	}
 
 	if mapType.UnsafeIsNil(ptr) {
@@ -167,22 +177,19 @@ func (decoder *mapDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 		key := decoder.keyType.UnsafeNew()
 		err := decoder.keyDecoder.Decode(key, iter)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
 			return err
 		}
 
 		elem := decoder.elemType.UnsafeNew()
 		err = decoder.elemDecoder.Decode(elem, iter)
 		if err != nil {
-			return err
+			return addErrorKey(err, fmt.Sprint(decoder.keyType.UnsafeIndirect(key)))
 		}
 
 		decoder.mapType.UnsafeSetIndex(ptr, key, elem)
 
 		if iter.numRead()-start > l {
-			return errors.New("out of bounds")
+			return iter.annotateError(errors.New("out of bounds"))
 		}
 	}
 
@@ -194,6 +201,15 @@ type dynamicMapKeyEncoder struct {
 	valType reflect2.Type
 }
 
+func (encoder *dynamicMapKeyEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	obj := encoder.valType.UnsafeIndirect(ptr)
+	enc, err := encoderOfMapKey(encoder.ctx, reflect2.TypeOf(obj))
+	if err != nil {
+		return 0, err
+	}
+	return enc.Size(reflect2.PtrOf(obj), stream)
+}
+
 func (encoder *dynamicMapKeyEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	obj := encoder.valType.UnsafeIndirect(ptr)
 	enc, err := encoderOfMapKey(encoder.ctx, reflect2.TypeOf(obj))
@@ -218,38 +234,218 @@ type mapEncoder struct {
 	elemEncoder valEncoder
 }
 
+func (encoder *mapEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	if *(*unsafe.Pointer)(ptr) == nil {
+		return sizeOfNil, nil
+	}
+
+	i := stream.reserveSize()
+	stream.sizes[i].offset = len(stream.mapEntries)
+	iter := encoder.mapType.UnsafeIterate(ptr)
+	for iter.HasNext() {
+		key, elem := iter.UnsafeNext()
+		stream.mapEntries = append(stream.mapEntries, key, elem)
+		stream.sizes[i].entries++
+	}
+
+	return sizeOfMapEntries(stream, i, encoder.keyEncoder, encoder.elemEncoder)
+}
+
 func (encoder *mapEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	if *(*unsafe.Pointer)(ptr) == nil {
 		stream.WriteNil()
 		return nil
 	}
+	return encodeMapEntries(stream, encoder.keyEncoder, encoder.elemEncoder)
+}
 
-	tmpStream := streamPool.BorrowStream(nil)
-	defer streamPool.ReturnStream(tmpStream)
+func (encoder *mapEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	iter := encoder.mapType.UnsafeIterate(ptr)
+	return !iter.HasNext(), nil
+}
+
+type sortKeysMapEncoder struct {
+	mapType     *reflect2.UnsafeMapType
+	keyEncoder  valEncoder
+	elemEncoder valEncoder
+}
 
+func (encoder *sortKeysMapEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	if *(*unsafe.Pointer)(ptr) == nil {
+		return sizeOfNil, nil
+	}
+
+	tmpStream := stream.cfg.streamPool.BorrowStream(nil)
+	defer stream.cfg.streamPool.ReturnStream(tmpStream)
+
+	var entries []sortedMapEntry
 	iter := encoder.mapType.UnsafeIterate(ptr)
-	for i := 0; iter.HasNext(); i++ {
+	for iter.HasNext() {
 		key, elem := iter.UnsafeNext()
-		err := encoder.keyEncoder.Encode(key, tmpStream)
+
+		start := tmpStream.Buffered()
+		err := tmpStream.encode(encoder.keyEncoder, key)
 		if err != nil {
-			return err
+			return 0, err
+		}
+
+		entries = append(entries, sortedMapEntry{
+			elem:     elem,
+			keyStart: start,
+			keyEnd:   tmpStream.Buffered(),
+		})
+	}
+
+	buf := tmpStream.Buffer()
+
+	var compareErr error
+	sort.SliceStable(entries, func(i, j int) bool {
+		keyA := buf[entries[i].keyStart:entries[i].keyEnd]
+		keyB := buf[entries[j].keyStart:entries[j].keyEnd]
+		result, err := Compare(keyA, 0, keyB, 0)
+		if err == nil && result == 0 {
+			// keys such as INT 1 and DOUBLE 1.0 are equal according
+			// to Compare but have different encodings
+			result = bytes.Compare(keyA, keyB)
 		}
+		if err == nil && result == 0 {
+			// distinct keys such as int(1) and int32(1) stored in a map
+			// with interface keys can have the same encoding, entries
+			// which are still equal after comparing the elements are
+			// encoded identically so their order doesn't matter
+			result, err = encoder.compareElems(stream, entries[i].elem, entries[j].elem)
+		}
+		if err != nil && compareErr == nil {
+			compareErr = err
+		}
+		return result < 0
+	})
+	if compareErr != nil {
+		return 0, compareErr
+	}
+
+	// the keys were already encoded in order to sort them so the encoded
+	// keys are recorded instead of being encoded again, only the elements
+	// are stored in stream.mapEntries
+	i := stream.reserveSize()
+	stream.sizes[i].offset = len(stream.mapEntries)
+	stream.sizes[i].entries = len(entries)
+	for _, entry := range entries {
+		stream.mapEntries = append(stream.mapEntries, entry.elem)
+	}
 
-		err = encoder.elemEncoder.Encode(elem, tmpStream)
+	length := 0
+	for _, entry := range entries {
+		stream.recordBytes(buf[entry.keyStart:entry.keyEnd])
+		length += entry.keyEnd - entry.keyStart
+
+		n, err := encoder.elemEncoder.Size(entry.elem, stream)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		length += n
 	}
 
-	stream.WriteTag(uint64(tmpStream.Buffered()), valueTypeObject)
-	if _, err := stream.Write(tmpStream.Buffer()); err != nil {
-		return err
+	stream.sizes[i].length = length
+	return sizeOfContainer(length), nil
+}
+
+func (encoder *sortKeysMapEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+	if *(*unsafe.Pointer)(ptr) == nil {
+		stream.WriteNil()
+		return nil
 	}
 
-	return nil
+	size := stream.takeSize()
+	stream.WriteTag(uint64(size.length), valueTypeObject)
+	start := stream.Buffered()
+
+	end := size.offset + size.entries
+	for j := size.offset; j < end; j++ {
+		stream.buf = append(stream.buf, stream.takeBytes()...)
+
+		if err := encoder.elemEncoder.Encode(stream.mapEntries[j], stream); err != nil {
+			return err
+		}
+	}
+
+	return stream.checkWritten(start, size.length)
 }
 
-func (encoder *mapEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+func (encoder *sortKeysMapEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
 	iter := encoder.mapType.UnsafeIterate(ptr)
 	return !iter.HasNext(), nil
 }
+
+// compareElems compares the encodings of two elements of a map.
+func (encoder *sortKeysMapEncoder) compareElems(stream *stream, a, b unsafe.Pointer) (int, error) {
+	tmpStream := stream.cfg.streamPool.BorrowStream(nil)
+	defer stream.cfg.streamPool.ReturnStream(tmpStream)
+
+	if err := tmpStream.encode(encoder.elemEncoder, a); err != nil {
+		return 0, err
+	}
+	aEnd := tmpStream.Buffered()
+	if err := tmpStream.encode(encoder.elemEncoder, b); err != nil {
+		return 0, err
+	}
+	buf := tmpStream.Buffer()
+	return bytes.Compare(buf[:aEnd], buf[aEnd:]), nil
+}
+
+// sortedMapEntry stores a pointer to an element of a map together with the
+// offsets of the encoded key.
+type sortedMapEntry struct {
+	elem     unsafe.Pointer
+	keyStart int
+	keyEnd   int
+}
+
+// sizeOfMapEntries computes the sizes of the entries of a map recorded in
+// stream.mapEntries, fills in the size reserved for the map at the index i and
+// returns the size of the map.
+func sizeOfMapEntries(stream *stream, i int, keyEncoder, elemEncoder valEncoder) (int, error) {
+	start := stream.sizes[i].offset
+	end := start + 2*stream.sizes[i].entries
+
+	length := 0
+	for j := start; j < end; j += 2 {
+		n, err := keyEncoder.Size(stream.mapEntries[j], stream)
+		if err != nil {
+			return 0, err
+		}
+		length += n
+
+		n, err = elemEncoder.Size(stream.mapEntries[j+1], stream)
+		if err != nil {
+			return 0, err
+		}
+		length += n
+	}
+
+	stream.sizes[i].length = length
+	return sizeOfContainer(length), nil
+}
+
+// encodeMapEntries writes a map whose entries were recorded by
+// sizeOfMapEntries.
+func encodeMapEntries(stream *stream, keyEncoder, elemEncoder valEncoder) error {
+	size := stream.takeSize()
+	stream.WriteTag(uint64(size.length), valueTypeObject)
+	start := stream.Buffered()
+
+	end := size.offset + 2*size.entries
+	for j := size.offset; j < end; j += 2 {
+		err := keyEncoder.Encode(stream.mapEntries[j], stream)
+		if err != nil {
+			return err
+		}
+
+		err = elemEncoder.Encode(stream.mapEntries[j+1], stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	return stream.checkWritten(start, size.length)
+}
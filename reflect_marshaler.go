@@ -8,10 +8,18 @@ import (
 	"github.com/modern-go/reflect2"
 )
 
+// Marshaler is the interface implemented by types that can marshal themselves
+// into BIPF. MarshalBIPF must return exactly one well-formed BIPF value, tag
+// included.
 type Marshaler interface {
 	MarshalBIPF() ([]byte, error)
 }
 
+// Unmarshaler is the interface implemented by types that can unmarshal a BIPF
+// description of themselves. UnmarshalBIPF receives exactly one encoded BIPF
+// value, tag included, which means that it can be passed the output of
+// MarshalBIPF. UnmarshalBIPF must copy the data if it wishes to retain the
+// data after returning.
 type Unmarshaler interface {
 	UnmarshalBIPF([]byte) error
 }
@@ -21,11 +29,11 @@ var unmarshalerType = reflect2.TypeOfPtr((*Unmarshaler)(nil)).Elem()
 var binaryMarshalerType = reflect2.TypeOfPtr((*encoding.BinaryMarshaler)(nil)).Elem()
 var binaryUnmarshalerType = reflect2.TypeOfPtr((*encoding.BinaryUnmarshaler)(nil)).Elem()
 
-func createDecoderOfMarshaler(typ reflect2.Type) valDecoder {
+func createDecoderOfMarshaler(ctx *ctx, typ reflect2.Type) valDecoder {
 	ptrType := reflect2.PtrTo(typ)
 	if ptrType.Implements(unmarshalerType) {
 		return &referenceDecoder{
-			&unmarshalerDecoder{ptrType},
+			&unmarshalerDecoder{ptrType, ctx.legacyMarshalers},
 		}
 	}
 	if ptrType.Implements(binaryUnmarshalerType) {
@@ -44,6 +52,7 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		}
 		var encoder valEncoder = &directMarshalerEncoder{
 			checkIsEmpty: checkIsEmpty,
+			checkOutput:  !ctx.legacyMarshalers,
 		}
 		return encoder, nil
 	}
@@ -55,6 +64,7 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		var encoder valEncoder = &marshalerEncoder{
 			valType:      typ,
 			checkIsEmpty: checkIsEmpty,
+			checkOutput:  !ctx.legacyMarshalers,
 		}
 		return encoder, nil
 	}
@@ -67,6 +77,7 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		var encoder valEncoder = &marshalerEncoder{
 			valType:      ptrType,
 			checkIsEmpty: checkIsEmpty,
+			checkOutput:  !ctx.legacyMarshalers,
 		}
 		return &referenceEncoder{encoder}, nil
 	}
@@ -75,7 +86,7 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		if err != nil {
 			return nil, err
 		}
-		enc, err := encoderOf(reflect2.TypeOf([]byte{}))
+		enc, err := ctx.encoderOf(reflect2.TypeOf([]byte{}))
 		if err != nil {
 			return nil, err
 		}
@@ -90,7 +101,7 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		if err != nil {
 			return nil, err
 		}
-		enc, err := encoderOf(reflect2.TypeOf([]byte{}))
+		enc, err := ctx.encoderOf(reflect2.TypeOf([]byte{}))
 		if err != nil {
 			return nil, err
 		}
@@ -107,7 +118,7 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 		if err != nil {
 			return nil, err
 		}
-		enc, err := encoderOf(reflect2.TypeOf([]byte{}))
+		enc, err := ctx.encoderOf(reflect2.TypeOf([]byte{}))
 		if err != nil {
 			return nil, err
 		}
@@ -121,25 +132,48 @@ func createEncoderOfMarshaler(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 	return nil, errors.New("encoder of marshaler not found")
 }
 
+// checkMarshalerOutput checks the output of MarshalBIPF if check is set. The
+// output has to be exactly one well-formed value.
+func checkMarshalerOutput(stream *stream, b []byte, check bool, typ reflect2.Type) error {
+	if check {
+		if err := checkValue(b, stream.cfg.maxDepth); err != nil {
+			return wrapf(err, "error calling MarshalBIPF for type %v", typ)
+		}
+	}
+	return nil
+}
+
 type marshalerEncoder struct {
 	checkIsEmpty checkIsEmpty
 	valType      reflect2.Type
+	checkOutput  bool
 }
 
-func (encoder *marshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+func (encoder *marshalerEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
 	obj := encoder.valType.UnsafeIndirect(ptr)
 	if encoder.valType.IsNullable() && reflect2.IsNil(obj) {
-		stream.WriteNil()
-		return nil
+		return sizeOfNil, nil
 	}
 	marshaler := obj.(Marshaler)
 	bytes, err := marshaler.MarshalBIPF()
 	if err != nil {
-		return err
-	} else {
-		_, err := stream.Write(bytes)
-		return err
+		return 0, err
+	}
+	if err := checkMarshalerOutput(stream, bytes, encoder.checkOutput, encoder.valType); err != nil {
+		return 0, err
+	}
+	stream.recordBytes(bytes)
+	return len(bytes), nil
+}
+
+func (encoder *marshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+	obj := encoder.valType.UnsafeIndirect(ptr)
+	if encoder.valType.IsNullable() && reflect2.IsNil(obj) {
+		stream.WriteNil()
+		return nil
 	}
+	_, err := stream.Write(stream.takeBytes())
+	return err
 }
 
 func (encoder *marshalerEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -148,21 +182,33 @@ func (encoder *marshalerEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
 
 type directMarshalerEncoder struct {
 	checkIsEmpty checkIsEmpty
+	checkOutput  bool
 }
 
-func (encoder *directMarshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+func (encoder *directMarshalerEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
 	marshaler := *(*Marshaler)(ptr)
 	if marshaler == nil {
-		stream.WriteNil()
-		return nil
+		return sizeOfNil, nil
 	}
 	bytes, err := marshaler.MarshalBIPF()
 	if err != nil {
-		return err
-	} else {
-		_, err := stream.Write(bytes)
-		return err
+		return 0, err
+	}
+	if err := checkMarshalerOutput(stream, bytes, encoder.checkOutput, reflect2.TypeOf(marshaler)); err != nil {
+		return 0, err
+	}
+	stream.recordBytes(bytes)
+	return len(bytes), nil
+}
+
+func (encoder *directMarshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+	marshaler := *(*Marshaler)(ptr)
+	if marshaler == nil {
+		stream.WriteNil()
+		return nil
 	}
+	_, err := stream.Write(stream.takeBytes())
+	return err
 }
 
 func (encoder *directMarshalerEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -175,20 +221,28 @@ type binaryMarshalerEncoder struct {
 	checkIsEmpty checkIsEmpty
 }
 
-func (encoder *binaryMarshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+func (encoder *binaryMarshalerEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
 	obj := encoder.valType.UnsafeIndirect(ptr)
 	if encoder.valType.IsNullable() && reflect2.IsNil(obj) {
-		stream.WriteNil()
-		return nil
+		return sizeOfNil, nil
 	}
 	marshaler := (obj).(encoding.BinaryMarshaler)
 	bytes, err := marshaler.MarshalBinary()
 	if err != nil {
-		return err
-	} else {
-		err := encoder.bytesEncoder.Encode(unsafe.Pointer(&bytes), stream)
-		return err
+		return 0, err
 	}
+	stream.recordBytes(bytes)
+	return encoder.bytesEncoder.Size(unsafe.Pointer(&bytes), stream)
+}
+
+func (encoder *binaryMarshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+	obj := encoder.valType.UnsafeIndirect(ptr)
+	if encoder.valType.IsNullable() && reflect2.IsNil(obj) {
+		stream.WriteNil()
+		return nil
+	}
+	bytes := stream.takeBytes()
+	return encoder.bytesEncoder.Encode(unsafe.Pointer(&bytes), stream)
 }
 
 func (encoder *binaryMarshalerEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -200,22 +254,27 @@ type directBinaryMarshalerEncoder struct {
 	checkIsEmpty  checkIsEmpty
 }
 
-func (encoder *directBinaryMarshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+func (encoder *directBinaryMarshalerEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
 	marshaler := *(*encoding.BinaryMarshaler)(ptr)
 	if marshaler == nil {
-		stream.WriteNil()
-		return nil
+		return sizeOfNil, nil
 	}
 	bytes, err := marshaler.MarshalBinary()
 	if err != nil {
-		return err
-	} else {
-		err := encoder.stringEncoder.Encode(unsafe.Pointer(&bytes), stream)
-		if err != nil {
-			return err
-		}
+		return 0, err
 	}
-	return nil
+	stream.recordBytes(bytes)
+	return encoder.stringEncoder.Size(unsafe.Pointer(&bytes), stream)
+}
+
+func (encoder *directBinaryMarshalerEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
+	marshaler := *(*encoding.BinaryMarshaler)(ptr)
+	if marshaler == nil {
+		stream.WriteNil()
+		return nil
+	}
+	bytes := stream.takeBytes()
+	return encoder.stringEncoder.Encode(unsafe.Pointer(&bytes), stream)
 }
 
 func (encoder *directBinaryMarshalerEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -224,6 +283,9 @@ func (encoder *directBinaryMarshalerEncoder) IsEmpty(ptr unsafe.Pointer) (bool,
 
 type unmarshalerDecoder struct {
 	valType reflect2.Type
+	// payloadOnly causes only the payload of the value to be passed to
+	// UnmarshalBIPF, see Config.LegacyMarshalers.
+	payloadOnly bool
 }
 
 func (decoder *unmarshalerDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
@@ -231,12 +293,13 @@ func (decoder *unmarshalerDecoder) Decode(ptr unsafe.Pointer, iter *iterator) er
 	obj := valType.UnsafeIndirect(ptr)
 	unmarshaler := obj.(Unmarshaler)
 
-	_, l, err := iter.readTag()
-	if err != nil {
-		return err
+	var buf []byte
+	var err error
+	if decoder.payloadOnly {
+		buf, err = iter.readPayload()
+	} else {
+		buf, err = iter.skipAndReturnBytes()
 	}
-	buf := make([]byte, l)
-	_, err = iter.Read(buf)
 	if err != nil {
 		return err
 	}
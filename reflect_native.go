@@ -220,6 +220,10 @@ func (codec *stringCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *stringCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfString(*((*string)(ptr))), nil
+}
+
 func (codec *stringCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	str := *((*string)(ptr))
 	return stream.WriteString(str)
@@ -246,6 +250,10 @@ func (codec *int8Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *int8Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfInt, nil
+}
+
 func (codec *int8Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteInt8(*((*int8)(ptr)))
 }
@@ -271,6 +279,10 @@ func (codec *int16Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *int16Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfInt, nil
+}
+
 func (codec *int16Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteInt16(*((*int16)(ptr)))
 }
@@ -296,6 +308,10 @@ func (codec *int32Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *int32Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfInt, nil
+}
+
 func (codec *int32Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteInt32(*((*int32)(ptr)))
 }
@@ -321,6 +337,10 @@ func (codec *int64Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *int64Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return stream.sizeOfInt64(*((*int64)(ptr)))
+}
+
 func (codec *int64Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteInt64(*((*int64)(ptr)))
 }
@@ -346,6 +366,10 @@ func (codec *uint8Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *uint8Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfInt, nil
+}
+
 func (codec *uint8Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteUint8(*((*uint8)(ptr)))
 }
@@ -371,6 +395,10 @@ func (codec *uint16Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *uint16Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfInt, nil
+}
+
 func (codec *uint16Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteUint16(*((*uint16)(ptr)))
 }
@@ -396,6 +424,10 @@ func (codec *uint32Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *uint32Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return stream.sizeOfUint64(uint64(*((*uint32)(ptr))))
+}
+
 func (codec *uint32Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteUint32(*((*uint32)(ptr)))
 }
@@ -421,6 +453,10 @@ func (codec *uint64Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *uint64Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return stream.sizeOfUint64(*((*uint64)(ptr)))
+}
+
 func (codec *uint64Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteUint64(*((*uint64)(ptr)))
 }
@@ -446,6 +482,10 @@ func (codec *float32Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *float32Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfDouble, nil
+}
+
 func (codec *float32Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteFloat32(*((*float32)(ptr)))
 }
@@ -471,6 +511,10 @@ func (codec *float64Codec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *float64Codec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfDouble, nil
+}
+
 func (codec *float64Codec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteFloat64(*((*float64)(ptr)))
 }
@@ -496,6 +540,10 @@ func (codec *boolCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return nil
 }
 
+func (codec *boolCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfBool, nil
+}
+
 func (codec *boolCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteBool(*((*bool)(ptr)))
 }
@@ -513,6 +561,10 @@ func (b bytesCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	return err
 }
 
+func (b bytesCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfBuffer(*((*[]byte)(ptr))), nil
+}
+
 func (b bytesCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return stream.WriteBuffer(*((*[]byte)(ptr)))
 }
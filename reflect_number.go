@@ -0,0 +1,175 @@
+package bipf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// Number is the literal text of a number which remembers whether it was
+// encoded as an INT or as a DOUBLE, similarly to json.Number. The text of an
+// INT is an integer such as "1" and the text of a DOUBLE always contains a
+// decimal point or an exponent, for example "1.0" or "1e+21". A Number is
+// encoded as an INT if its text is an integer which fits in an INT and as a
+// DOUBLE otherwise, therefore Numbers are encoded using the same type which
+// they were decoded from. The zero value of Number is an INT equal to 0.
+type Number string
+
+// IntNumber returns a Number which is encoded as an INT.
+func IntNumber(v int32) Number {
+	return Number(strconv.FormatInt(int64(v), 10))
+}
+
+// DoubleNumber returns a Number which is encoded as a DOUBLE.
+func DoubleNumber(v float64) Number {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return Number(s)
+}
+
+// Kind returns KindInt or KindDouble depending on how the number is encoded.
+func (n Number) Kind() Kind {
+	if _, ok := n.int32(); ok {
+		return KindInt
+	}
+	return KindDouble
+}
+
+// Int64 returns the number as an int64. An error is returned if the number
+// isn't an integer which fits in an int64.
+func (n Number) Int64() (int64, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 {
+		return 0, fmt.Errorf("number %s can't be represented as int64", n)
+	}
+	return int64(f), nil
+}
+
+// Float64 returns the number as a float64.
+func (n Number) Float64() (float64, error) {
+	if n == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// String returns the literal text of the number.
+func (n Number) String() string {
+	return string(n)
+}
+
+// MarshalJSON implements json.Marshaler so that numbers are represented in
+// JSON as numbers and not as strings. NaN and infinities can't be represented
+// in JSON.
+func (n Number) MarshalJSON() ([]byte, error) {
+	if n == "" {
+		return []byte("0"), nil
+	}
+	if _, err := strconv.ParseFloat(string(n), 64); err != nil || !json.Valid([]byte(n)) {
+		return nil, fmt.Errorf("number %q can't be represented in JSON", string(n))
+	}
+	return []byte(n), nil
+}
+
+// int32 returns the number as an int32 if it is encoded as an INT.
+func (n Number) int32() (int32, bool) {
+	if n == "" {
+		return 0, true
+	}
+	i, err := strconv.ParseInt(string(n), 10, 32)
+	return int32(i), err == nil
+}
+
+var numberType = reflect2.TypeOfPtr((*Number)(nil)).Elem()
+
+func createEncoderOfNumber(ctx *ctx, typ reflect2.Type) valEncoder {
+	if typ == numberType {
+		return &numberCodec{}
+	}
+	return nil
+}
+
+func createDecoderOfNumber(ctx *ctx, typ reflect2.Type) valDecoder {
+	if typ == numberType {
+		return &numberCodec{}
+	}
+	return nil
+}
+
+type numberCodec struct {
+}
+
+func (codec *numberCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	ok, err := iter.CheckNilIsNext()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		*((*Number)(ptr)), err = iter.readNumber()
+		return err
+	}
+
+	return nil
+}
+
+func (codec *numberCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	n := *((*Number)(ptr))
+	if _, ok := n.int32(); ok {
+		return sizeOfInt, nil
+	}
+	if _, err := n.Float64(); err != nil {
+		return 0, &UnsupportedValueError{Value: reflect.ValueOf(n), Str: fmt.Sprintf("invalid number %q", string(n))}
+	}
+	return sizeOfDouble, nil
+}
+
+func (codec *numberCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
+	n := *((*Number)(ptr))
+	if i, ok := n.int32(); ok {
+		return stream.WriteInt32(i)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return err
+	}
+	return stream.WriteFloat64(f)
+}
+
+func (codec *numberCodec) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return *((*Number)(ptr)) == "", nil
+}
+
+// readNumber reads an INT or a DOUBLE.
+func (iter *iterator) readNumber() (Number, error) {
+	offset := iter.numRead()
+	v, l, err := iter.readTag()
+	if err != nil {
+		return "", err
+	}
+
+	switch v {
+	case valueTypeInt:
+		i, err := iter.readInt32Payload(l)
+		return IntNumber(i), err
+	case valueTypeDouble:
+		f, err := iter.readFloat64Payload(l)
+		return DoubleNumber(f), err
+	default:
+		return "", newUnmarshalTypeError(v, numberType.Type1(), offset)
+	}
+}
@@ -89,6 +89,13 @@ type optionalEncoder struct {
 	ValueEncoder valEncoder
 }
 
+func (encoder *optionalEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	if *((*unsafe.Pointer)(ptr)) == nil {
+		return sizeOfNil, nil
+	}
+	return encoder.ValueEncoder.Size(*((*unsafe.Pointer)(ptr)), stream)
+}
+
 func (encoder *optionalEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	if *((*unsafe.Pointer)(ptr)) == nil {
 		stream.WriteNil()
@@ -106,6 +113,13 @@ type dereferenceEncoder struct {
 	ValueEncoder valEncoder
 }
 
+func (encoder *dereferenceEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	if *((*unsafe.Pointer)(ptr)) == nil {
+		return sizeOfNil, nil
+	}
+	return encoder.ValueEncoder.Size(*((*unsafe.Pointer)(ptr)), stream)
+}
+
 func (encoder *dereferenceEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	if *((*unsafe.Pointer)(ptr)) == nil {
 		stream.WriteNil()
@@ -140,6 +154,10 @@ type referenceEncoder struct {
 	encoder valEncoder
 }
 
+func (encoder *referenceEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return encoder.encoder.Size(unsafe.Pointer(&ptr), stream)
+}
+
 func (encoder *referenceEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	return encoder.encoder.Encode(unsafe.Pointer(&ptr), stream)
 }
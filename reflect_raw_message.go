@@ -0,0 +1,82 @@
+package bipf
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// RawMessage is a raw encoded BIPF value, tag included. It can be used to
+// delay decoding of a part of a message or to pass it through without
+// changing its encoding.
+type RawMessage []byte
+
+var rawMessageType = reflect2.TypeOfPtr((*RawMessage)(nil)).Elem()
+
+func createEncoderOfRawMessage(ctx *ctx, typ reflect2.Type) valEncoder {
+	if typ == rawMessageType {
+		return &rawMessageCodec{}
+	}
+	return nil
+}
+
+func createDecoderOfRawMessage(ctx *ctx, typ reflect2.Type) valDecoder {
+	if typ == rawMessageType {
+		return &rawMessageCodec{}
+	}
+	return nil
+}
+
+type rawMessageCodec struct {
+}
+
+func (codec *rawMessageCodec) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	b, err := iter.skipAndReturnBytes()
+	if err != nil {
+		return err
+	}
+	*((*RawMessage)(ptr)) = b
+	return nil
+}
+
+func (codec *rawMessageCodec) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	raw := *((*RawMessage)(ptr))
+	if raw == nil {
+		return sizeOfNil, nil
+	}
+	if err := checkRawMessage(raw); err != nil {
+		return 0, err
+	}
+	return len(raw), nil
+}
+
+func (codec *rawMessageCodec) Encode(ptr unsafe.Pointer, stream *stream) error {
+	raw := *((*RawMessage)(ptr))
+	if raw == nil {
+		stream.WriteNil()
+		return nil
+	}
+	if err := checkRawMessage(raw); err != nil {
+		return err
+	}
+	_, err := stream.Write(raw)
+	return err
+}
+
+// checkRawMessage checks that the tag of a raw message describes a value which
+// ends exactly where the raw message ends.
+func checkRawMessage(raw RawMessage) error {
+	end, err := skipAt(raw, 0)
+	if err != nil {
+		return wrap(err, "invalid raw message")
+	}
+	if end != len(raw) {
+		return errors.New("invalid raw message: more than one value")
+	}
+	return nil
+}
+
+func (codec *rawMessageCodec) IsEmpty(ptr unsafe.Pointer) (bool, error) {
+	return len(*((*RawMessage)(ptr))) == 0, nil
+}
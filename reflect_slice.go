@@ -2,7 +2,6 @@ package bipf
 
 import (
 	"errors"
-	"fmt"
 	"unsafe"
 
 	"github.com/modern-go/reflect2"
@@ -31,34 +30,53 @@ type sliceEncoder struct {
 	elemEncoder valEncoder
 }
 
+func (encoder *sliceEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	if encoder.sliceType.UnsafeIsNil(ptr) {
+		return sizeOfNil, nil
+	}
+	n := encoder.sliceType.UnsafeLengthOf(ptr)
+	if n == 0 {
+		return sizeOfContainer(0), nil
+	}
+
+	i := stream.reserveSize()
+	length := 0
+	for j := 0; j < n; j++ {
+		elemPtr := encoder.sliceType.UnsafeGetIndex(ptr, j)
+		elemSize, err := encoder.elemEncoder.Size(elemPtr, stream)
+		if err != nil {
+			return 0, wrapf(err, "type '%v'", encoder.sliceType)
+		}
+		length += elemSize
+	}
+	stream.sizes[i].length = length
+	return sizeOfContainer(length), nil
+}
+
 func (encoder *sliceEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	if encoder.sliceType.UnsafeIsNil(ptr) {
 		stream.WriteNil()
 		return nil
 	}
-	length := encoder.sliceType.UnsafeLengthOf(ptr)
-	if length == 0 {
+	n := encoder.sliceType.UnsafeLengthOf(ptr)
+	if n == 0 {
 		stream.WriteEmptyArray()
 		return nil
 	}
 
-	tmpStream := streamPool.BorrowStream(nil)
-	defer streamPool.ReturnStream(tmpStream)
+	length := stream.takeSize().length
+	stream.WriteTag(uint64(length), valueTypeArray)
+	start := stream.Buffered()
 
-	for i := 0; i < length; i++ {
+	for i := 0; i < n; i++ {
 		elemPtr := encoder.sliceType.UnsafeGetIndex(ptr, i)
-		err := encoder.elemEncoder.Encode(elemPtr, tmpStream)
+		err := encoder.elemEncoder.Encode(elemPtr, stream)
 		if err != nil {
 			return wrapf(err, "type '%v'", encoder.sliceType)
 		}
 	}
 
-	stream.WriteTag(uint64(tmpStream.Buffered()), valueTypeArray)
-	if _, err := stream.Write(tmpStream.Buffer()); err != nil {
-		return err
-	}
-
-	return nil
+	return stream.checkWritten(start, length)
 }
 
 func (encoder *sliceEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -71,9 +89,10 @@ type sliceDecoder struct {
 }
 
 func (decoder *sliceDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	offset := iter.numRead()
 	typ, l, err := iter.readTag()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	sliceType := decoder.sliceType
@@ -84,7 +103,7 @@ func (decoder *sliceDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	}
 
 	if typ != valueTypeArray {
-		return fmt.Errorf("incorrect type: %v", typ)
+		return newUnmarshalTypeError(typ, decoder.sliceType.Type1(), offset)
 	}
 
 	start := iter.numRead()
@@ -96,11 +115,11 @@ func (decoder *sliceDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 		elemPtr := sliceType.UnsafeGetIndex(ptr, i)
 		err := decoder.elemDecoder.Decode(elemPtr, iter)
 		if err != nil {
-			return err
+			return addErrorIndex(err, i)
 		}
 
 		if iter.numRead()-start > l {
-			return errors.New("out of bounds")
+			return iter.annotateError(errors.New("out of bounds"))
 		}
 
 		i++
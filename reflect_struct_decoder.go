@@ -9,7 +9,7 @@ import (
 )
 
 func decoderOfStruct(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
-	bindings := map[string]*binding{}
+	bindings := map[string]*Binding{}
 	structDescriptor, err := describeStruct(ctx, typ)
 	if err != nil {
 		return nil, err
@@ -21,7 +21,7 @@ func decoderOfStruct(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
 				bindings[fromName] = binding
 				continue
 			}
-			ignoreOld, ignoreNew := resolveConflictBinding(old, binding)
+			ignoreOld, ignoreNew := resolveConflictBinding(ctx.frozenConfig, old, binding)
 			if ignoreOld {
 				delete(bindings, fromName)
 			}
@@ -30,27 +30,57 @@ func decoderOfStruct(ctx *ctx, typ reflect2.Type) (valDecoder, error) {
 			}
 		}
 	}
-	fields := map[string]*structFieldDecoder{}
+	indexes := map[*structFieldDecoder]int{}
+	fields := map[string]structFieldMatch{}
 	for k, binding := range bindings {
-		fields[k] = binding.Decoder.(*structFieldDecoder)
+		index, found := indexes[binding.fieldDecoder]
+		if !found {
+			index = len(indexes)
+			indexes[binding.fieldDecoder] = index
+		}
+		fields[k] = structFieldMatch{binding.fieldDecoder, index}
 	}
 
-	for k, binding := range bindings {
-		if _, found := fields[strings.ToLower(k)]; !found {
-			fields[strings.ToLower(k)] = binding.Decoder.(*structFieldDecoder)
+	if !ctx.caseSensitive {
+		for k := range bindings {
+			if _, found := fields[strings.ToLower(k)]; !found {
+				fields[strings.ToLower(k)] = fields[k]
+			}
 		}
 	}
 
-	return &generalStructDecoder{typ, fields, false}, nil
+	return &generalStructDecoder{
+		typ:                     typ,
+		fields:                  fields,
+		numFields:               len(indexes),
+		disallowUnknownFields:   ctx.disallowUnknownFields,
+		disallowDuplicateFields: ctx.disallowDuplicateFields,
+		caseSensitive:           ctx.caseSensitive,
+	}, nil
 }
 
+// structFieldMatch is a field matched by a key. Each field of a struct has a
+// distinct index which is used to track the keys which matched it.
+type structFieldMatch struct {
+	decoder *structFieldDecoder
+	index   int
+}
+
+// maxStackDecodedFields is the number of fields of a struct up to which the
+// keys which matched them are tracked without allocating.
+const maxStackDecodedFields = 32
+
 type generalStructDecoder struct {
-	typ                   reflect2.Type
-	fields                map[string]*structFieldDecoder
-	disallowUnknownFields bool
+	typ                     reflect2.Type
+	fields                  map[string]structFieldMatch
+	numFields               int
+	disallowUnknownFields   bool
+	disallowDuplicateFields bool
+	caseSensitive           bool
 }
 
 func (decoder *generalStructDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
+	offset := iter.numRead()
 	typ, l, err := iter.readTag()
 	if err != nil {
 		return err
@@ -61,7 +91,7 @@ func (decoder *generalStructDecoder) Decode(ptr unsafe.Pointer, iter *iterator)
 	}
 
 	if typ != valueTypeObject {
-		return errors.New("unexpected type")
+		return newUnmarshalTypeError(typ, decoder.typ.Type1(), offset)
 	}
 
 	start := iter.numRead()
@@ -70,8 +100,18 @@ func (decoder *generalStructDecoder) Decode(ptr unsafe.Pointer, iter *iterator)
 		return err
 	}
 
+	var decodedFields []string
+	if decoder.disallowDuplicateFields {
+		var stackDecodedFields [maxStackDecodedFields]string
+		if decoder.numFields <= len(stackDecodedFields) {
+			decodedFields = stackDecodedFields[:decoder.numFields]
+		} else {
+			decodedFields = make([]string, decoder.numFields)
+		}
+	}
+
 	for iter.numRead()-start < l {
-		if err := decoder.decodeOneField(ptr, iter); err != nil {
+		if err := decoder.decodeOneField(ptr, iter, decodedFields); err != nil {
 			return err
 		}
 	}
@@ -83,27 +123,55 @@ func (decoder *generalStructDecoder) Decode(ptr unsafe.Pointer, iter *iterator)
 	return nil
 }
 
-func (decoder *generalStructDecoder) decodeOneField(ptr unsafe.Pointer, iter *iterator) error {
-	var fieldDecoder *structFieldDecoder
-
+// decodeOneField decodes a single key-value pair. If decodedFields isn't nil
+// then it records the key which matched each field, indexed by the field's
+// index, and is used to detect multiple keys matching the same field. Keys
+// are never empty as an empty key can't match a field.
+func (decoder *generalStructDecoder) decodeOneField(ptr unsafe.Pointer, iter *iterator, decodedFields []string) error {
+	offset := iter.numRead()
 	field, err := iter.ReadString()
 	if err != nil {
 		return err
 	}
 
-	fieldDecoder = decoder.fields[field]
-	if fieldDecoder == nil {
-		fieldDecoder = decoder.fields[strings.ToLower(field)]
+	match, found := decoder.fields[field]
+	if !found && !decoder.caseSensitive {
+		match, found = decoder.fields[strings.ToLower(field)]
 	}
-
-	if fieldDecoder == nil {
+	fieldDecoder := match.decoder
+
+	if !found {
+		if decoder.disallowUnknownFields {
+			return &UnknownFieldError{
+				Key:    field,
+				Type:   decoder.typ.Type1(),
+				Offset: int64(offset),
+			}
+		}
 		if err := iter.skip(); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	return fieldDecoder.Decode(ptr, iter)
+	if decodedFields != nil {
+		previousField := decodedFields[match.index]
+		if previousField != "" {
+			return &DuplicateFieldError{
+				PreviousKey: previousField,
+				Key:         field,
+				StructField: fieldDecoder.field.Name(),
+				Type:        decoder.typ.Type1(),
+				Offset:      int64(offset),
+			}
+		}
+		decodedFields[match.index] = field
+	}
+
+	if err := fieldDecoder.Decode(ptr, iter); err != nil {
+		return addErrorKey(err, field)
+	}
+	return nil
 }
 
 type structFieldDecoder struct {
@@ -114,6 +182,11 @@ type structFieldDecoder struct {
 func (decoder *structFieldDecoder) Decode(ptr unsafe.Pointer, iter *iterator) error {
 	fieldPtr := decoder.field.UnsafeGet(ptr)
 	if err := decoder.fieldDecoder.Decode(fieldPtr, iter); err != nil {
+		var syntaxErr *SyntaxError
+		if errorField(err) != nil || errors.As(err, &syntaxErr) {
+			// the path to the field or the offset is recorded in the error
+			return err
+		}
 		return wrap(err, decoder.field.Name())
 	}
 	return nil
@@ -10,7 +10,7 @@ import (
 
 func encoderOfStruct(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 	type bindingTo struct {
-		binding *binding
+		binding *Binding
 		toName  string
 		ignored bool
 	}
@@ -29,7 +29,7 @@ func encoderOfStruct(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 				if oldBinding.toName != toName {
 					continue
 				}
-				oldBinding.ignored, newBinding.ignored = resolveConflictBinding(oldBinding.binding, newBinding.binding)
+				oldBinding.ignored, newBinding.ignored = resolveConflictBinding(ctx.frozenConfig, oldBinding.binding, newBinding.binding)
 			}
 			orderedBindings = append(orderedBindings, newBinding)
 		}
@@ -41,7 +41,7 @@ func encoderOfStruct(ctx *ctx, typ reflect2.Type) (valEncoder, error) {
 	for _, bindingTo := range orderedBindings {
 		if !bindingTo.ignored {
 			finalOrderedFields = append(finalOrderedFields, structFieldTo{
-				encoder: bindingTo.binding.Encoder.(*structFieldEncoder),
+				encoder: bindingTo.binding.fieldEncoder,
 				toName:  bindingTo.toName,
 			})
 		}
@@ -73,9 +73,9 @@ func createCheckIsEmpty(ctx *ctx, typ reflect2.Type) (checkIsEmpty, error) {
 	}
 }
 
-func resolveConflictBinding(old, new *binding) (ignoreOld, ignoreNew bool) {
-	newTagged := new.Field.Tag().Get(tagKey) != ""
-	oldTagged := old.Field.Tag().Get(tagKey) != ""
+func resolveConflictBinding(cfg *frozenConfig, old, new *Binding) (ignoreOld, ignoreNew bool) {
+	newTagged := new.Field.Tag().Get(cfg.tagKey) != ""
+	oldTagged := old.Field.Tag().Get(cfg.tagKey) != ""
 	if newTagged {
 		if oldTagged {
 			if len(old.levels) > len(new.levels) {
@@ -108,6 +108,15 @@ type structFieldEncoder struct {
 	omitempty    bool
 }
 
+func (encoder *structFieldEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	fieldPtr := encoder.field.UnsafeGet(ptr)
+	n, err := encoder.fieldEncoder.Size(fieldPtr, stream)
+	if err != nil {
+		return 0, wrapf(err, "field name '%s'", encoder.field.Name())
+	}
+	return n, nil
+}
+
 func (encoder *structFieldEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	fieldPtr := encoder.field.UnsafeGet(ptr)
 	err := encoder.fieldEncoder.Encode(fieldPtr, stream)
@@ -131,6 +140,21 @@ func (encoder *structFieldEncoder) IsEmbeddedPtrNil(ptr unsafe.Pointer) bool {
 	return isEmbeddedPtrNil.IsEmbeddedPtrNil(fieldPtr)
 }
 
+// omitted reports whether the field is left out of the encoding of the
+// struct.
+func (encoder *structFieldEncoder) omitted(ptr unsafe.Pointer) (bool, error) {
+	if encoder.omitempty {
+		isEmpty, err := encoder.IsEmpty(ptr)
+		if err != nil {
+			return false, err
+		}
+		if isEmpty {
+			return true, nil
+		}
+	}
+	return encoder.IsEmbeddedPtrNil(ptr), nil
+}
+
 type isEmbeddedPtrNil interface {
 	IsEmbeddedPtrNil(ptr unsafe.Pointer) bool
 }
@@ -145,40 +169,54 @@ type structFieldTo struct {
 	toName  string
 }
 
+func (encoder *structEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	i := stream.reserveSize()
+	length := 0
+	for _, field := range encoder.fields {
+		omitted, err := field.encoder.omitted(ptr)
+		if err != nil {
+			return 0, err
+		}
+		if omitted {
+			continue
+		}
+
+		n, err := field.encoder.Size(ptr, stream)
+		if err != nil {
+			return 0, err
+		}
+		length += sizeOfString(field.toName) + n
+	}
+	stream.sizes[i].length = length
+	return sizeOfContainer(length), nil
+}
+
 func (encoder *structEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
-	tmpStream := streamPool.BorrowStream(nil)
-	defer streamPool.ReturnStream(tmpStream)
+	length := stream.takeSize().length
+	stream.WriteTag(uint64(length), valueTypeObject)
+	start := stream.Buffered()
 
 	for _, field := range encoder.fields {
-		isEmpty, err := field.encoder.IsEmpty(ptr)
+		omitted, err := field.encoder.omitted(ptr)
 		if err != nil {
 			return err
 		}
-		if field.encoder.omitempty && isEmpty {
-			continue
-		}
-		if field.encoder.IsEmbeddedPtrNil(ptr) {
+		if omitted {
 			continue
 		}
 
-		err = tmpStream.WriteString(field.toName)
+		err = stream.WriteString(field.toName)
 		if err != nil {
 			return err
 		}
 
-		err = field.encoder.Encode(ptr, tmpStream)
+		err = field.encoder.Encode(ptr, stream)
 		if err != nil {
 			return err
 		}
 	}
 
-	stream.WriteTag(uint64(tmpStream.Buffered()), valueTypeObject)
-	_, err := stream.Write(tmpStream.Buffer())
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return stream.checkWritten(start, length)
 }
 
 func (encoder *structEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
@@ -188,6 +226,10 @@ func (encoder *structEncoder) IsEmpty(ptr unsafe.Pointer) (bool, error) {
 type emptyStructEncoder struct {
 }
 
+func (encoder *emptyStructEncoder) Size(ptr unsafe.Pointer, stream *stream) (int, error) {
+	return sizeOfContainer(0), nil
+}
+
 func (encoder *emptyStructEncoder) Encode(ptr unsafe.Pointer, stream *stream) error {
 	stream.WriteEmptyObject()
 	return nil
@@ -0,0 +1,233 @@
+package bipf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// SeekKey returns the offset of the value stored under the given key in the
+// BIPF OBJECT which starts at the offset start in buf. If the object doesn't
+// contain the key or the value at start isn't an OBJECT SeekKey returns -1.
+//
+// SeekKey doesn't decode the object, values stored under other keys are
+// skipped using their length prefixes. This makes it possible to efficiently
+// read a single field of a large object.
+func SeekKey(buf []byte, start int, key string) (int, error) {
+	typ, length, pos, err := readTagAt(buf, start)
+	if err != nil {
+		return -1, err
+	}
+
+	if typ != valueTypeObject {
+		return -1, nil
+	}
+
+	end := pos + length
+
+	for pos < end {
+		keyTyp, keyLength, keyStart, err := readTagAt(buf, pos)
+		if err != nil {
+			return -1, err
+		}
+
+		valueStart := keyStart + keyLength
+		if valueStart >= end {
+			return -1, newSyntaxError("out of bounds", uint64(pos))
+		}
+
+		if keyTyp == valueTypeString && string(buf[keyStart:valueStart]) == key {
+			return valueStart, nil
+		}
+
+		pos, err = skipAt(buf, valueStart)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	if pos > end {
+		return -1, newSyntaxError("out of bounds", uint64(pos))
+	}
+
+	return -1, nil
+}
+
+// Seeker locates a value nested in encoded BIPF OBJECTs and ARRAYs without
+// decoding them. Seekers are created using CompilePath and can be reused and
+// used concurrently.
+type Seeker struct {
+	steps []seekStep
+}
+
+type seekStep struct {
+	// key is the encoded key, tag included, or nil if this step selects an
+	// array element.
+	key   []byte
+	index int
+}
+
+// CompilePath compiles a path which can be used to locate a value nested in
+// encoded BIPF OBJECTs and ARRAYs. Each element of the path must either be a
+// string, which selects the value stored under a key of an OBJECT, or an int,
+// which selects an element of an ARRAY.
+func CompilePath(path ...any) (*Seeker, error) {
+	seeker := &Seeker{}
+	for _, elem := range path {
+		switch v := elem.(type) {
+		case string:
+			key := binary.AppendUvarint(nil, uint64(len(v))<<3|uint64(valueTypeString))
+			key = append(key, v...)
+			seeker.steps = append(seeker.steps, seekStep{key: key})
+		case int:
+			if v < 0 {
+				return nil, fmt.Errorf("negative array index %d", v)
+			}
+			seeker.steps = append(seeker.steps, seekStep{index: v})
+		default:
+			return nil, fmt.Errorf("invalid path element of type %T", elem)
+		}
+	}
+	return seeker, nil
+}
+
+// MustCompilePath is like CompilePath but panics if the path can't be
+// compiled.
+func MustCompilePath(path ...any) *Seeker {
+	seeker, err := CompilePath(path...)
+	if err != nil {
+		panic(err)
+	}
+	return seeker
+}
+
+// Seek follows the path starting from the value which starts at the offset
+// start in buf and returns the offset of the value located at the end of the
+// path. If an OBJECT doesn't contain a key, an ARRAY is too short or a value
+// along the path isn't an OBJECT or an ARRAY as required by the path then Seek
+// returns -1.
+func (s *Seeker) Seek(buf []byte, start int) (int, error) {
+	pos := start
+	for _, step := range s.steps {
+		var err error
+		if step.key != nil {
+			pos, err = seekEncodedKey(buf, pos, step.key)
+		} else {
+			pos, err = seekIndex(buf, pos, step.index)
+		}
+		if err != nil || pos < 0 {
+			return -1, err
+		}
+	}
+	return pos, nil
+}
+
+// Unmarshal locates the value found at the path in the BIPF-encoded data and
+// stores it in the value pointed to by v. If there is no value at the path
+// Unmarshal returns an error.
+func (s *Seeker) Unmarshal(data []byte, v any) error {
+	return ConfigDefault.UnmarshalSeek(data, s, v)
+}
+
+func seekEncodedKey(buf []byte, start int, key []byte) (int, error) {
+	typ, length, pos, err := readTagAt(buf, start)
+	if err != nil {
+		return -1, err
+	}
+
+	if typ != valueTypeObject {
+		return -1, nil
+	}
+
+	end := pos + length
+
+	for pos < end {
+		if pos+len(key) < end && bytes.Equal(buf[pos:pos+len(key)], key) {
+			return pos + len(key), nil
+		}
+
+		pos, err = skipAt(buf, pos)
+		if err != nil {
+			return -1, err
+		}
+
+		if pos >= end {
+			return -1, newSyntaxError("out of bounds", uint64(pos))
+		}
+
+		pos, err = skipAt(buf, pos)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	if pos > end {
+		return -1, newSyntaxError("out of bounds", uint64(pos))
+	}
+
+	return -1, nil
+}
+
+func seekIndex(buf []byte, start int, index int) (int, error) {
+	typ, length, pos, err := readTagAt(buf, start)
+	if err != nil {
+		return -1, err
+	}
+
+	if typ != valueTypeArray {
+		return -1, nil
+	}
+
+	end := pos + length
+
+	for i := 0; pos < end; i++ {
+		if i == index {
+			return pos, nil
+		}
+
+		pos, err = skipAt(buf, pos)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	if pos > end {
+		return -1, newSyntaxError("out of bounds", uint64(pos))
+	}
+
+	return -1, nil
+}
+
+// readTagAt reads the tag of the value which starts at the offset start in
+// buf. It returns the type of the value, the length of its payload and the
+// offset at which the payload starts.
+func readTagAt(buf []byte, start int) (valueType, int, int, error) {
+	if start < 0 || start >= len(buf) {
+		return 0, 0, 0, newSyntaxError("out of bounds", uint64(start))
+	}
+
+	v, n := binary.Uvarint(buf[start:])
+	if n <= 0 {
+		return 0, 0, 0, newSyntaxError("error reading uvarint", uint64(start))
+	}
+
+	typ := byte(v) & 0x07
+	length := v >> 3
+	payloadStart := start + n
+
+	if length > uint64(len(buf)-payloadStart) {
+		return 0, 0, 0, newSyntaxError("out of bounds", uint64(start))
+	}
+
+	return valueType(typ), int(length), payloadStart, nil
+}
+
+// skipAt returns the offset at which the value which starts at the offset
+// start in buf ends.
+func skipAt(buf []byte, start int) (int, error) {
+	_, length, payloadStart, err := readTagAt(buf, start)
+	if err != nil {
+		return 0, err
+	}
+	return payloadStart + length, nil
+}
@@ -4,15 +4,27 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"unsafe"
 )
 
 type stream struct {
+	cfg *frozenConfig
 	out io.Writer
 	buf []byte
+
+	// sizes, nextSize, recorded, mapEntries, encoders and nextEncoder hold
+	// the state of the encoding pass, see encode.
+	sizes       []encodedSize
+	nextSize    int
+	recorded    []byte
+	mapEntries  []unsafe.Pointer
+	encoders    []valEncoder
+	nextEncoder int
 }
 
-func newStream(out io.Writer, bufSize int) *stream {
+func newStream(cfg *frozenConfig, out io.Writer, bufSize int) *stream {
 	return &stream{
+		cfg: cfg,
 		out: out,
 		buf: make([]byte, 0, bufSize),
 	}
@@ -21,6 +33,12 @@ func newStream(out io.Writer, bufSize int) *stream {
 func (stream *stream) Reset(out io.Writer) {
 	stream.out = out
 	stream.buf = stream.buf[:0]
+	stream.sizes = stream.sizes[:0]
+	stream.nextSize = 0
+	stream.recorded = stream.recorded[:0]
+	stream.mapEntries = stream.mapEntries[:0]
+	stream.encoders = stream.encoders[:0]
+	stream.nextEncoder = 0
 }
 
 func (stream *stream) Buffered() int {
@@ -33,14 +51,13 @@ func (stream *stream) Buffer() []byte {
 
 func (stream *stream) Write(p []byte) (nn int, err error) {
 	stream.buf = append(stream.buf, p...)
-	if stream.out != nil {
-		nn, err = stream.out.Write(stream.buf)
-		stream.buf = stream.buf[nn:]
-		return
-	}
 	return len(p), nil
 }
 
+func (stream *stream) truncate(n int) {
+	stream.buf = stream.buf[:n]
+}
+
 func (stream *stream) Flush() error {
 	if stream.out == nil {
 		return nil
@@ -102,3 +119,68 @@ func (stream *stream) WriteTag(length uint64, typ valueType) {
 	v := length<<3 | uint64(typ)
 	stream.buf = binary.AppendUvarint(stream.buf, v)
 }
+
+// pendingTags records the tags of containers whose payloads were written to a
+// buffer before the lengths of the payloads were known. The buffer is written
+// without the tags, which are inserted when it is copied using appendTo. This
+// way every byte is copied once regardless of how deeply the containers are
+// nested.
+type pendingTags struct {
+	// containers holds the tags missing from the buffer in the order in
+	// which the containers were opened.
+	containers []pendingContainer
+
+	// tagBytes is the total length of the tags of the closed containers.
+	tagBytes int
+}
+
+type pendingContainer struct {
+	// offset is the offset in the buffer at which the tag of the container
+	// has to be inserted.
+	offset int
+
+	// length is the length of the payload of the container, including the
+	// tags of nested containers.
+	length int
+
+	// tagBytes is the value of pendingTags.tagBytes when the container was
+	// opened.
+	tagBytes int
+
+	typ valueType
+}
+
+// open records a container whose payload starts at the given offset in the
+// buffer and returns the index which has to be passed to close.
+func (t *pendingTags) open(offset int, typ valueType) int {
+	t.containers = append(t.containers, pendingContainer{offset: offset, tagBytes: t.tagBytes, typ: typ})
+	return len(t.containers) - 1
+}
+
+// close records that the payload of the container ends at the given offset in
+// the buffer.
+func (t *pendingTags) close(index int, end int) {
+	container := &t.containers[index]
+	container.length = end - container.offset + t.tagBytes - container.tagBytes
+	t.tagBytes += sizeOfTag(container.length)
+}
+
+// appendTo appends buf to dst inserting the tags of the containers.
+func (t *pendingTags) appendTo(dst []byte, buf []byte) []byte {
+	pos := 0
+	for _, container := range t.containers {
+		dst = append(dst, buf[pos:container.offset]...)
+		dst = binary.AppendUvarint(dst, uint64(container.length)<<3|uint64(container.typ))
+		pos = container.offset
+	}
+	return append(dst, buf[pos:]...)
+}
+
+func (stream *stream) WriteExtended(subtype uint64, data []byte) error {
+	var subtypeBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(subtypeBuf[:], subtype)
+	stream.WriteTag(uint64(n+len(data)), valueTypeExtended)
+	stream.buf = append(stream.buf, subtypeBuf[:n]...)
+	stream.buf = append(stream.buf, data...)
+	return nil
+}
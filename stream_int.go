@@ -3,7 +3,9 @@ package bipf
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
+	"reflect"
 )
 
 func (stream *stream) WriteUint8(v uint8) error {
@@ -23,7 +25,7 @@ func (stream *stream) WriteInt16(v int16) error {
 }
 
 func (stream *stream) WriteUint32(v uint32) error {
-	return stream.WriteInt32(int32(v))
+	return stream.WriteUint64(uint64(v))
 }
 
 func (stream *stream) WriteInt32(v int32) error {
@@ -33,18 +35,113 @@ func (stream *stream) WriteInt32(v int32) error {
 }
 
 func (stream *stream) WriteUint64(v uint64) error {
-	if v > math.MaxUint32 {
-		return errors.New("value > MaxUint32")
+	typ, err := stream.uint64Type(v)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case valueTypeInt:
+		return stream.WriteInt32(int32(v))
+	case valueTypeDouble:
+		return stream.WriteFloat64(float64(v))
+	default:
+		return stream.WriteExtended(stream.cfg.uint64ExtendedType, binary.LittleEndian.AppendUint64(nil, v))
 	}
-	return stream.WriteInt32(int32(v))
 }
 
 func (stream *stream) WriteInt64(v int64) error {
-	if v > math.MaxInt32 {
-		return errors.New("value > MaxInt32")
+	typ, err := stream.int64Type(v)
+	if err != nil {
+		return err
 	}
-	if v < math.MinInt32 {
-		return errors.New("value < MinInt32")
+	switch typ {
+	case valueTypeInt:
+		return stream.WriteInt32(int32(v))
+	case valueTypeDouble:
+		return stream.WriteFloat64(float64(v))
+	default:
+		return stream.WriteExtended(stream.cfg.int64ExtendedType, binary.LittleEndian.AppendUint64(nil, uint64(v)))
 	}
-	return stream.WriteInt32(int32(v))
+}
+
+// sizeOfUint64 returns the length of the encoding of v written by
+// WriteUint64.
+func (stream *stream) sizeOfUint64(v uint64) (int, error) {
+	typ, err := stream.uint64Type(v)
+	if err != nil {
+		return 0, err
+	}
+	return sizeOfInteger(typ, stream.cfg.uint64ExtendedType), nil
+}
+
+// sizeOfInt64 returns the length of the encoding of v written by WriteInt64.
+func (stream *stream) sizeOfInt64(v int64) (int, error) {
+	typ, err := stream.int64Type(v)
+	if err != nil {
+		return 0, err
+	}
+	return sizeOfInteger(typ, stream.cfg.int64ExtendedType), nil
+}
+
+func sizeOfInteger(typ valueType, subtype uint64) int {
+	switch typ {
+	case valueTypeInt:
+		return sizeOfInt
+	case valueTypeDouble:
+		return sizeOfDouble
+	default:
+		return sizeOfExtended(subtype, 8)
+	}
+}
+
+// uint64Type returns the type of the value used to encode v according to the
+// integer policy.
+func (stream *stream) uint64Type(v uint64) (valueType, error) {
+	if v <= math.MaxInt32 {
+		return valueTypeInt, nil
+	}
+	switch stream.cfg.integerPolicy {
+	case IntegerPolicyDouble:
+		f := float64(v)
+		if f >= 1<<64 || uint64(f) != v {
+			return 0, &UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%d can't be represented exactly as a DOUBLE", v)}
+		}
+		return valueTypeDouble, nil
+	case IntegerPolicyExtended:
+		return valueTypeExtended, stream.checkExtendedIntegerTypes()
+	default:
+		return 0, &UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%d > MaxInt32", v)}
+	}
+}
+
+// int64Type returns the type of the value used to encode v according to the
+// integer policy.
+func (stream *stream) int64Type(v int64) (valueType, error) {
+	if v <= math.MaxInt32 && v >= math.MinInt32 {
+		return valueTypeInt, nil
+	}
+	switch stream.cfg.integerPolicy {
+	case IntegerPolicyDouble:
+		f := float64(v)
+		if f >= 1<<63 || int64(f) != v {
+			return 0, &UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%d can't be represented exactly as a DOUBLE", v)}
+		}
+		return valueTypeDouble, nil
+	case IntegerPolicyExtended:
+		return valueTypeExtended, stream.checkExtendedIntegerTypes()
+	default:
+		if v > 0 {
+			return 0, &UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%d > MaxInt32", v)}
+		}
+		return 0, &UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%d < MinInt32", v)}
+	}
+}
+
+// checkExtendedIntegerTypes checks that signed and unsigned integers encoded
+// as EXTENDED values can be told apart.
+func (stream *stream) checkExtendedIntegerTypes() error {
+	if stream.cfg.int64ExtendedType == stream.cfg.uint64ExtendedType {
+		return errors.New("Int64ExtendedType and Uint64ExtendedType must be different")
+	}
+	return nil
 }
@@ -0,0 +1,166 @@
+package bipf
+
+import (
+	"errors"
+	"unsafe"
+)
+
+const (
+	sizeOfNil    = 1
+	sizeOfBool   = 2
+	sizeOfInt    = 5
+	sizeOfDouble = 9
+)
+
+// encodedSize is recorded by valEncoder.Size and consumed by
+// valEncoder.Encode, see encode.
+type encodedSize struct {
+	// length is the length of the payload of a container or of the bytes
+	// stored in stream.recorded.
+	length int
+
+	// offset is the offset of the bytes in stream.recorded or, in the
+	// case of maps, the index of the first entry in stream.mapEntries. An
+	// entry is a key followed by an element or, if the keys are sorted, only
+	// an element as the encoded keys are stored in stream.recorded.
+	offset int
+
+	// entries is the number of entries of a map.
+	entries int
+}
+
+// encode writes the value pointed to by ptr using encoder. The tag of a
+// container has to be written before its payload but depends on the length
+// of the payload. Therefore the value is traversed twice. First Size computes
+// the lengths of the payloads of all containers and records them in the order
+// in which they are encountered. Then Encode writes the value consuming the
+// recorded lengths in the same order. This way every byte is written directly
+// to the stream exactly once.
+//
+// Values whose encoding can't be predicted without producing it, such as the
+// output of marshalers and custom encoders, are produced by Size and stored
+// until Encode writes them. The order in which the entries of a map are
+// visited is also stored as it changes between iterations and so are the
+// keys of maps encoded with Config.SortMapKeys, which are encoded in order to
+// sort them, and the encoders of values stored in interfaces to avoid
+// looking them up twice.
+//
+// Calls to encode can be nested as the state of the outer call is restored
+// before returning.
+func (stream *stream) encode(encoder valEncoder, ptr unsafe.Pointer) error {
+	sizes := len(stream.sizes)
+	nextSize := stream.nextSize
+	recorded := len(stream.recorded)
+	mapEntries := len(stream.mapEntries)
+	encoders := len(stream.encoders)
+	nextEncoder := stream.nextEncoder
+
+	_, err := encoder.Size(ptr, stream)
+	if err == nil {
+		stream.nextSize = sizes
+		stream.nextEncoder = encoders
+		err = encoder.Encode(ptr, stream)
+	}
+
+	stream.sizes = stream.sizes[:sizes]
+	stream.nextSize = nextSize
+	stream.recorded = stream.recorded[:recorded]
+	stream.mapEntries = stream.mapEntries[:mapEntries]
+	stream.encoders = stream.encoders[:encoders]
+	stream.nextEncoder = nextEncoder
+	return err
+}
+
+// reserveSize appends an entry to the recorded sizes and returns its index so
+// that it can be filled in once the size of a container is known.
+func (stream *stream) reserveSize() int {
+	stream.sizes = append(stream.sizes, encodedSize{})
+	return len(stream.sizes) - 1
+}
+
+// takeSize returns the next recorded size.
+func (stream *stream) takeSize() encodedSize {
+	size := stream.sizes[stream.nextSize]
+	stream.nextSize++
+	return size
+}
+
+// recordEncoder stores the encoder used to compute the size of a value stored
+// in an interface so that it can be retrieved using takeEncoder while
+// encoding.
+func (stream *stream) recordEncoder(encoder valEncoder) {
+	stream.encoders = append(stream.encoders, encoder)
+}
+
+// takeEncoder returns the next encoder stored using recordEncoder.
+func (stream *stream) takeEncoder() valEncoder {
+	encoder := stream.encoders[stream.nextEncoder]
+	stream.nextEncoder++
+	return encoder
+}
+
+// recordBytes stores b, which was produced while computing sizes, so that it
+// can be retrieved using takeBytes while encoding.
+func (stream *stream) recordBytes(b []byte) {
+	offset := len(stream.recorded)
+	stream.recorded = append(stream.recorded, b...)
+	stream.recordAppended(offset)
+}
+
+// recordAppended records the bytes which were appended to stream.recorded
+// since the given offset so that they can be retrieved using takeBytes.
+func (stream *stream) recordAppended(offset int) {
+	stream.sizes = append(stream.sizes, encodedSize{
+		length: len(stream.recorded) - offset,
+		offset: offset,
+	})
+}
+
+// takeBytes returns the next bytes stored using recordBytes.
+func (stream *stream) takeBytes() []byte {
+	size := stream.takeSize()
+	return stream.recorded[size.offset : size.offset+size.length]
+}
+
+// checkWritten checks that the payload of a container which started at the
+// offset start in the buffer has the length computed by Size. It can only
+// differ if the value was modified while being encoded.
+func (stream *stream) checkWritten(start, length int) error {
+	if stream.Buffered()-start != length {
+		return errors.New("value was modified while being encoded")
+	}
+	return nil
+}
+
+// sizeOfTag returns the length of a tag describing a payload of the given
+// length.
+func sizeOfTag(length int) int {
+	return sizeOfUvarint(uint64(length) << 3)
+}
+
+func sizeOfUvarint(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+func sizeOfString(s string) int {
+	return sizeOfTag(len(s)) + len(s)
+}
+
+func sizeOfBuffer(b []byte) int {
+	return sizeOfTag(len(b)) + len(b)
+}
+
+func sizeOfExtended(subtype uint64, dataLength int) int {
+	length := sizeOfUvarint(subtype) + dataLength
+	return sizeOfTag(length) + length
+}
+
+// sizeOfContainer returns the length of a container with a payload of the
+// given length.
+func sizeOfContainer(length int) int {
+	return sizeOfTag(length) + length
+}
@@ -0,0 +1,77 @@
+package bipf
+
+// Writer writes BIPF values one by one without using reflection. Writers are
+// passed to the custom encoders registered using RegisterTypeEncoder,
+// RegisterFieldEncoder or an Extension. Each call to a write method must
+// write exactly one value.
+type Writer struct {
+	stream *stream
+
+	// tags holds the tags of the containers written using WriteArray and
+	// WriteObject. The values stored in containers are written to the
+	// stream without the tags of the containers which are inserted once
+	// all values were written, see extensionEncoder.
+	tags pendingTags
+}
+
+// WriteString writes a STRING.
+func (w *Writer) WriteString(s string) error {
+	return w.stream.WriteString(s)
+}
+
+// WriteBuffer writes a BUFFER.
+func (w *Writer) WriteBuffer(b []byte) error {
+	return w.stream.WriteBuffer(b)
+}
+
+// WriteInt32 writes an INT.
+func (w *Writer) WriteInt32(v int32) error {
+	return w.stream.WriteInt32(v)
+}
+
+// WriteFloat64 writes a DOUBLE.
+func (w *Writer) WriteFloat64(v float64) error {
+	return w.stream.WriteFloat64(v)
+}
+
+// WriteBool writes a BOOLNULL set to true or false.
+func (w *Writer) WriteBool(v bool) error {
+	return w.stream.WriteBool(v)
+}
+
+// WriteNil writes a BOOLNULL set to null.
+func (w *Writer) WriteNil() error {
+	w.stream.WriteNil()
+	return nil
+}
+
+// WriteExtended writes an EXTENDED value of the given subtype containing data.
+func (w *Writer) WriteExtended(subtype uint64, data []byte) error {
+	return w.stream.WriteExtended(subtype, data)
+}
+
+// WriteVal writes the BIPF encoding of v. See the documentation for Marshal
+// for details about the conversion of Go values to BIPF.
+func (w *Writer) WriteVal(v any) error {
+	return w.stream.WriteVal(v)
+}
+
+// WriteArray writes an ARRAY containing the values written by fn.
+func (w *Writer) WriteArray(fn func(w *Writer) error) error {
+	return w.writeContainer(valueTypeArray, fn)
+}
+
+// WriteObject writes an OBJECT containing the values written by fn. The values
+// written by fn must alternate between keys and values.
+func (w *Writer) WriteObject(fn func(w *Writer) error) error {
+	return w.writeContainer(valueTypeObject, fn)
+}
+
+func (w *Writer) writeContainer(typ valueType, fn func(w *Writer) error) error {
+	index := w.tags.open(w.stream.Buffered(), typ)
+	if err := fn(w); err != nil {
+		return err
+	}
+	w.tags.close(index, w.stream.Buffered())
+	return nil
+}